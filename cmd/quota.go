@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/socks5"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var QuotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "show and reset usage of socks5 users with quotas",
+}
+
+var QuotaShowCmd = &cobra.Command{
+	Use:   "show [flags] [USER...]",
+	Short: "show usage of users, or all of them",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := loadQuotaState(cmd)
+		if err != nil {
+			return err
+		}
+		params := make(url.Values)
+		if path, _ := cmd.Flags().GetString("quota"); path != "" {
+			params.Set(socks5.ParamQuota, path)
+		}
+		quotas, err := socks5.ParseQuotas(params)
+		if err != nil {
+			return err
+		}
+
+		usernames := args
+		if len(usernames) == 0 {
+			for username := range state.Users {
+				usernames = append(usernames, username)
+			}
+			for username := range quotas {
+				if _, found := state.Users[username]; !found {
+					usernames = append(usernames, username)
+				}
+			}
+			sort.Strings(usernames)
+		}
+
+		now := time.Now()
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "USER\tTODAY\tDAILY QUOTA\tTHIS MONTH\tMONTHLY QUOTA\tEXHAUSTED")
+		for _, username := range usernames {
+			usage, found := state.Users[username]
+			if !found {
+				usage = &socks5.UserUsage{}
+			}
+			usage.Roll(now)
+			quota := quotas[username]
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%t\n", username,
+				utils.FormatSize(usage.DayBytes), formatQuota(quota.Daily),
+				utils.FormatSize(usage.MonthBytes), formatQuota(quota.Monthly),
+				usage.Exhausted(quota))
+		}
+		return writer.Flush()
+	},
+}
+
+var QuotaResetCmd = &cobra.Command{
+	Use:   "reset [flags] [USER...]",
+	Short: "reset usage of users, or all of them. running instances drop their usage within their save interval",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := cmd.Flags().GetString("state")
+		if err != nil {
+			return err
+		}
+		// running instances merge their usage into the file under the same lock, so neither change is lost.
+		_, err = socks5.UpdateQuotaState(path, func(state *socks5.QuotaState) (bool, error) {
+			if len(args) == 0 {
+				for _, usage := range state.Users {
+					usage.Reset()
+				}
+			}
+			for _, username := range args {
+				usage, found := state.Users[username]
+				if !found {
+					return false, fmt.Errorf("no usage recorded for user %q", username)
+				}
+				usage.Reset()
+			}
+			return true, nil
+		})
+		return err
+	},
+}
+
+func loadQuotaState(cmd *cobra.Command) (*socks5.QuotaState, error) {
+	path, err := cmd.Flags().GetString("state")
+	if err != nil {
+		return nil, err
+	}
+	return socks5.LoadQuotaState(path)
+}
+
+func formatQuota(quota int64) string {
+	if quota <= 0 {
+		return "-"
+	}
+	return utils.FormatSize(quota)
+}
+
+func init() {
+	QuotaCmd.PersistentFlags().String("state", "", "path of quota state file, as in socks5.quota_state")
+	_ = QuotaCmd.MarkPersistentFlagRequired("state")
+	QuotaShowCmd.Flags().String("quota", "", "quota file, as in socks5.quota")
+	QuotaCmd.AddCommand(QuotaShowCmd, QuotaResetCmd)
+	RootCmd.AddCommand(QuotaCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"github.com/hadi77ir/go-logging"
+	"github.com/hadi77ir/wsproxy/pkg/admin"
 	"github.com/hadi77ir/wsproxy/pkg/proxy"
 	"github.com/hadi77ir/wsproxy/pkg/utils"
 	"github.com/spf13/cobra"
@@ -10,10 +11,13 @@ import (
 	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"syscall"
 
 	// socks5 handler
 	_ "github.com/hadi77ir/wsproxy/pkg/socks5"
+	// exec handler
+	_ "github.com/hadi77ir/wsproxy/pkg/spawn"
 )
 
 const HelpFooter = "\n" +
@@ -22,7 +26,7 @@ const HelpFooter = "\n" +
 const GoVersionFormat = "Built with Go toolchain %s"
 
 var RootCmd = &cobra.Command{
-	Use:   "wsproxy [flags] LOCAL REMOTE",
+	Use:   "wsproxy [flags] LOCAL REMOTE [REMOTE...]",
 	Short: "wsproxy - yet another websockify implementation, with additional functionality",
 	Long: `wsproxy is a utility suited to forward (sometimes called "tunneling")
 connections of one transport over another. for example: WebSocket.`,
@@ -39,13 +43,13 @@ connections of one transport over another. for example: WebSocket.`,
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		if len(args) != 2 {
-			logger.Log(logging.ErrorLevel, "incorrect number of args: =", len(args), ", has to be = 2")
+		if len(args) < 2 {
+			logger.Log(logging.ErrorLevel, "incorrect number of args: =", len(args), ", has to be >= 2")
 			return
 		}
 
 		local := args[0]
-		remote := args[1]
+		remotes := args[1:]
 
 		localOptions, err := cmd.Flags().GetStringArray("lo")
 		if err != nil {
@@ -70,8 +74,62 @@ connections of one transport over another. for example: WebSocket.`,
 		}
 
 		localEndpoint := proxy.Endpoint{Addr: local, TransportParams: parsedLocalOptions}
-		remoteEndpoint := proxy.Endpoint{Addr: remote, TransportParams: parsedRemoteOptions}
-		instance := proxy.NewProxy(localEndpoint, remoteEndpoint, logger, sigChan)
+		remoteEndpoints := make([]proxy.Endpoint, len(remotes))
+		for i, remote := range remotes {
+			remoteEndpoints[i] = proxy.Endpoint{Addr: remote, TransportParams: parsedRemoteOptions}
+		}
+		instance := proxy.NewProxy(localEndpoint, remoteEndpoints, logger, sigChan)
+
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			logger.Log(logging.ErrorLevel, "error reading tunnel name:", err)
+			return
+		}
+		if name != "" {
+			instance.SetName(name)
+		}
+		accessLog, err := openAccessLog(cmd)
+		if err != nil {
+			logger.Log(logging.ErrorLevel, "error opening access log:", err)
+			return
+		}
+		if accessLog != nil {
+			instance.SetAccessLog(accessLog)
+			defer accessLog.Close()
+		}
+
+		adminAddr, err := cmd.Flags().GetString("admin")
+		if err != nil {
+			logger.Log(logging.ErrorLevel, "error reading admin address:", err)
+			return
+		}
+		if adminAddr != "" {
+			adminServer, err := admin.NewServer(adminAddr, nil, instance, logger)
+			if err != nil {
+				logger.Log(logging.ErrorLevel, "error starting admin server:", err)
+				return
+			}
+			defer adminServer.Close()
+			tokenFile, err := cmd.Flags().GetString("admin-token-file")
+			if err != nil {
+				logger.Log(logging.ErrorLevel, "error reading admin token file:", err)
+				return
+			}
+			if tokenFile != "" {
+				token, err := os.ReadFile(tokenFile)
+				if err != nil {
+					logger.Log(logging.ErrorLevel, "error reading admin token file:", err)
+					return
+				}
+				if err = adminServer.EnableConnections(instance, strings.TrimSpace(string(token))); err != nil {
+					logger.Log(logging.ErrorLevel, "error starting admin server:", err)
+					return
+				}
+				instance.TrackConnections()
+			}
+			logger.Log(logging.InfoLevel, "Admin server runs on", adminAddr)
+			go adminServer.Serve()
+		}
 
 		if err := instance.Run(); err != nil {
 			logger.Log(logging.ErrorLevel, err)
@@ -81,6 +139,27 @@ connections of one transport over another. for example: WebSocket.`,
 	},
 }
 
+// openAccessLog returns nil if no access log is set.
+func openAccessLog(cmd *cobra.Command) (*proxy.AccessLog, error) {
+	path, err := cmd.Flags().GetString("access-log")
+	if err != nil || path == "" {
+		return nil, err
+	}
+	maxSizeFlag, err := cmd.Flags().GetString("access-log-max-size")
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := utils.ParseSize(maxSizeFlag)
+	if err != nil {
+		return nil, err
+	}
+	maxBackups, err := cmd.Flags().GetInt("access-log-max-backups")
+	if err != nil {
+		return nil, err
+	}
+	return proxy.NewAccessLog(path, maxSize, maxBackups)
+}
+
 func getVersion() string {
 	version := "unknown"
 	if info, ok := debug.ReadBuildInfo(); ok {
@@ -99,4 +178,10 @@ func init() {
 	// Flags
 	RootCmd.Flags().StringArrayP("lo", "l", nil, "transport parameters for local endpoint, one at a time")
 	RootCmd.Flags().StringArrayP("ro", "r", nil, "transport parameters for remote endpoint, one at a time")
+	RootCmd.Flags().String("admin", "", "address of admin HTTP endpoint, e.g. tcp://127.0.0.1:9900")
+	RootCmd.Flags().String("admin-token-file", "", "file holding the token which enables listing and closing connections through the admin endpoint")
+	RootCmd.Flags().String("name", "", "name of the tunnel in access log records, local address by default")
+	RootCmd.Flags().String("access-log", "", "file to write a JSON line to for each connection once it's closed")
+	RootCmd.Flags().String("access-log-max-size", "100M", "size beyond which access log is rotated, zero never rotates")
+	RootCmd.Flags().Int("access-log-max-backups", 5, "number of rotated access logs to keep")
 }
@@ -14,6 +14,7 @@ require (
 	go.uber.org/automaxprocs v1.5.1
 	golang.org/x/crypto v0.6.0
 	golang.org/x/net v0.6.0
+	golang.org/x/sys v0.5.0
 )
 
 require (
@@ -22,5 +23,4 @@ require (
 	github.com/klauspost/compress v1.15.15 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.5.0 // indirect
 )
@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/proxy"
+)
+
+const readHeaderTimeout = time.Duration(10) * time.Second
+
+type StatusProvider interface {
+	RemotesStatus() []proxy.UpstreamStatus
+}
+
+// ConnectionManager lists and closes live connections of a proxy.
+type ConnectionManager interface {
+	Status() proxy.TunnelStatus
+	Connections() []proxy.AccessRecord
+	CloseConnection(id uint64) bool
+	CloseUserConnections(user string) int
+}
+
+// Server exposes state of a running proxy over HTTP.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	mux      *http.ServeMux
+	provider StatusProvider
+	manager  ConnectionManager
+	token    []byte
+	logger   logging.Logger
+}
+
+// NewServer listens on addr, which may be any of the supported local endpoints, e.g. "tcp://127.0.0.1:9900" or
+// "unix:///run/wsproxy-admin.sock".
+func NewServer(addr string, transportParams url.Values, provider StatusProvider, logger logging.Logger) (*Server, error) {
+	listener, err := N.ListenURL(addr, transportParams)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		mux:      http.NewServeMux(),
+		provider: provider,
+		logger:   logger,
+	}
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.server = &http.Server{Handler: s.mux, ReadHeaderTimeout: readHeaderTimeout}
+	return s, nil
+}
+
+// EnableConnections serves status of the tunnel on "/status", and its live connections on "/connections", which can be
+// closed with DELETE requests, for one of them by id on "/connections/ID", or for all of a user's on
+// "/connections?user=USER". requests have to carry token as "Authorization: Bearer TOKEN". it has to be called before
+// Serve, and only on unix sockets and loopback addresses.
+func (s *Server) EnableConnections(manager ConnectionManager, token string) error {
+	if token == "" {
+		return E.ErrMissingPart("admin token")
+	}
+	if !isLocalAddr(s.listener.Addr()) {
+		return E.ErrAdminNotLocal
+	}
+	s.manager = manager
+	s.token = []byte(token)
+	s.mux.HandleFunc("/status", s.authorized(s.handleStatus))
+	s.mux.HandleFunc("/connections", s.authorized(s.handleConnections))
+	s.mux.HandleFunc("/connections/", s.authorized(s.handleConnection))
+	return nil
+}
+
+// isLocalAddr tells whether only local clients can reach addr, which is a unix socket or a loopback address.
+func isLocalAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	}
+	return false
+}
+
+func (s *Server) Serve() {
+	err := s.server.Serve(s.listener)
+	if err != nil && err != http.ErrServerClosed {
+		s.logger.Log(logging.ErrorLevel, "Admin server error:", err)
+	}
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+type healthResponse struct {
+	Healthy bool                   `json:"healthy"`
+	Remotes []proxy.UpstreamStatus `json:"remotes"`
+}
+
+// handleHealth reports healthy as long as at least one of the remotes is healthy. remotes which are not balanced have
+// no state and are always considered healthy.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	response := healthResponse{Healthy: true, Remotes: s.provider.RemotesStatus()}
+	if len(response.Remotes) > 0 {
+		response.Healthy = false
+		for _, remote := range response.Remotes {
+			if remote.Healthy {
+				response.Healthy = true
+				break
+			}
+		}
+	}
+	if response.Remotes == nil {
+		response.Remotes = []proxy.UpstreamStatus{}
+	}
+	writeJSON(w, response, response.Healthy)
+}
+
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.manager.Status(), true)
+}
+
+type closedResponse struct {
+	Closed int `json:"closed"`
+}
+
+// handleConnections lists live connections, those of a user if "user" is given, or closes all connections of the user.
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	user, userFound := r.URL.Query()["user"]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		records := s.manager.Connections()
+		if userFound {
+			filtered := make([]proxy.AccessRecord, 0, len(records))
+			for _, record := range records {
+				if record.User == user[0] {
+					filtered = append(filtered, record)
+				}
+			}
+			records = filtered
+		}
+		writeJSON(w, records, true)
+	case http.MethodDelete:
+		if !userFound || user[0] == "" {
+			http.Error(w, "user is required", http.StatusBadRequest)
+			return
+		}
+		closed := s.manager.CloseUserConnections(user[0])
+		s.logger.Log(logging.InfoLevel, "Closed", closed, "connections of user", user[0], "through admin API")
+		writeJSON(w, closedResponse{Closed: closed}, true)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleConnection closes a connection by id.
+func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/connections/"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.manager.CloseConnection(id) {
+		http.NotFound(w, r)
+		return
+	}
+	s.logger.Log(logging.InfoLevel, "Closed connection", id, "through admin API")
+	writeJSON(w, closedResponse{Closed: 1}, true)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(v)
+}
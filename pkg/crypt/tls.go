@@ -115,7 +115,7 @@ func LoadX509PairsBytesFromParams(parameters url.Values) (certs [][]byte, keys [
 	certs = make([][]byte, pairCount)
 	keys = make([][]byte, pairCount)
 	for i := 0; i < len(keyPaths); i++ {
-		keys[i], certs[i], err = LoadX509PairBytes(certPaths[i], keyPaths[i])
+		certs[i], keys[i], err = LoadX509PairBytes(certPaths[i], keyPaths[i])
 		if err != nil {
 			return nil, nil, err
 		}
@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const maxCacheEntries = 4096
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+type cacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// cache keeps answers, including empty ones, for as long as their TTL allows.
+type cache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[cacheKey]cacheEntry)}
+}
+
+func (c *cache) get(name string, qtype dnsmessage.Type) ([]net.IP, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, found := c.entries[cacheKey{name, qtype}]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+func (c *cache) put(name string, qtype dnsmessage.Type, ips []net.IP, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[cacheKey]cacheEntry)
+		}
+	}
+	c.entries[cacheKey{name, qtype}] = cacheEntry{ips: ips, expires: now.Add(ttl)}
+}
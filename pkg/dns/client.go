@@ -0,0 +1,179 @@
+package dns
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// negativeTTL is used for empty answers which don't come with an SOA record telling how long to keep them.
+const negativeTTL = time.Duration(30) * time.Second
+
+// cachingResolver sends its own queries, for A and AAAA records in parallel, and caches answers. names are resolved as
+// given, without any search domains.
+type cachingResolver struct {
+	exchange exchangeFunc
+	cache    *cache
+}
+
+func (r *cachingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	name := strings.ToLower(host)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	qtypes := []dnsmessage.Type{dnsmessage.TypeAAAA, dnsmessage.TypeA}
+	results := make([][]net.IP, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(i int, qtype dnsmessage.Type) {
+			defer wg.Done()
+			results[i], errs[i] = r.lookup(ctx, name, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var ips []net.IP
+	for _, result := range results {
+		ips = append(ips, result...)
+	}
+	if len(ips) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, errors.ErrNoAddress
+	}
+	return ips, nil
+}
+
+func (r *cachingResolver) lookup(ctx context.Context, name string, qtype dnsmessage.Type) ([]net.IP, error) {
+	if ips, found := r.cache.get(name, qtype); found {
+		return ips, nil
+	}
+	query, err := buildQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	ips, ttl, err := parseResponse(response, query, qtype)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(name, qtype, ips, ttl)
+	return ips, nil
+}
+
+func buildQuery(name string, qtype dnsmessage.Type) ([]byte, error) {
+	parsedName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	builder := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:               uint16(rand.Uint32()),
+		RecursionDesired: true,
+	})
+	builder.EnableCompression()
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(dnsmessage.Question{Name: parsedName, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// parseResponse returns addresses in the response, and how long they may be cached for.
+func parseResponse(response []byte, query []byte, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !header.Response || len(response) < 2 || response[0] != query[0] || response[1] != query[1] {
+		return nil, 0, errors.ErrInvalidDNSResponse
+	}
+	if header.RCode != dnsmessage.RCodeSuccess && header.RCode != dnsmessage.RCodeNameError {
+		return nil, 0, errors.ErrDNSRcode(header.RCode.String())
+	}
+	if err = parser.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	first := true
+	for {
+		answer, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// answer of a CNAME chain may only be cached as long as the whole chain.
+		if first || answer.TTL < ttl {
+			ttl, first = answer.TTL, false
+		}
+		switch {
+		case answer.Type == dnsmessage.TypeA && qtype == dnsmessage.TypeA:
+			resource, err := parser.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(resource.A[:]))
+		case answer.Type == dnsmessage.TypeAAAA && qtype == dnsmessage.TypeAAAA:
+			resource, err := parser.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(resource.AAAA[:]))
+		default:
+			if err = parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if len(ips) > 0 {
+		return ips, time.Duration(ttl) * time.Second, nil
+	}
+	return nil, negativeCacheTTL(&parser), nil
+}
+
+// negativeCacheTTL finds out how long an empty answer may be cached, from the SOA record in authority section, as
+// described in RFC 2308.
+func negativeCacheTTL(parser *dnsmessage.Parser) time.Duration {
+	for {
+		authority, err := parser.AuthorityHeader()
+		if err != nil {
+			return negativeTTL
+		}
+		if authority.Type != dnsmessage.TypeSOA {
+			if err = parser.SkipAuthority(); err != nil {
+				return negativeTTL
+			}
+			continue
+		}
+		soa, err := parser.SOAResource()
+		if err != nil {
+			return negativeTTL
+		}
+		ttl := authority.TTL
+		if soa.MinTTL < ttl {
+			ttl = soa.MinTTL
+		}
+		return time.Duration(ttl) * time.Second
+	}
+}
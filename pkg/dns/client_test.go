@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mustName(name string) dnsmessage.Name {
+	return dnsmessage.MustNewName(name)
+}
+
+// buildResponse answers query with the given records.
+func buildResponse(t *testing.T, query []byte, rcode dnsmessage.RCode, answers, authorities []dnsmessage.Resource) []byte {
+	t.Helper()
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: header.ID, Response: true, RCode: rcode, RecursionDesired: true},
+		Questions:   questions,
+		Answers:     answers,
+		Authorities: authorities,
+	}
+	response, err := message.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func aRecord(name string, ttl uint32, ip string) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.AResource{A: a},
+	}
+}
+
+func aaaaRecord(name string, ttl uint32, ip string) dnsmessage.Resource {
+	var aaaa [16]byte
+	copy(aaaa[:], net.ParseIP(ip))
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.AAAAResource{AAAA: aaaa},
+	}
+}
+
+func cnameRecord(name string, ttl uint32, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.CNAMEResource{CNAME: mustName(target)},
+	}
+}
+
+func soaRecord(name string, ttl uint32, minTTL uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body: &dnsmessage.SOAResource{NS: mustName("ns." + name), MBox: mustName("hostmaster." + name),
+			Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: minTTL},
+	}
+}
+
+// loopingResponse answers query with a record whose name is a compression pointer to itself.
+func loopingResponse(query []byte) []byte {
+	response := append([]byte{}, query[:12]...)
+	response[2] |= 0x80
+	// no questions, one answer.
+	binary.BigEndian.PutUint16(response[4:], 0)
+	binary.BigEndian.PutUint16(response[6:], 1)
+	response = append(response, 0xc0, 12)
+	response = binary.BigEndian.AppendUint16(response, uint16(dnsmessage.TypeA))
+	response = binary.BigEndian.AppendUint16(response, uint16(dnsmessage.ClassINET))
+	response = binary.BigEndian.AppendUint32(response, 60)
+	response = binary.BigEndian.AppendUint16(response, 4)
+	return append(response, 192, 0, 2, 1)
+}
+
+func TestParseResponse(t *testing.T) {
+	queryA, err := buildQuery("example.com.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryAAAA, err := buildQuery("example.com.", dnsmessage.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := buildResponse(t, queryA, dnsmessage.RCodeSuccess, []dnsmessage.Resource{aRecord("example.com.", 300, "192.0.2.1")}, nil)
+	otherID := append([]byte{}, answered...)
+	otherID[0] ^= 0xff
+	notResponse := append([]byte{}, answered...)
+	notResponse[2] &^= 0x80
+
+	for _, tc := range []struct {
+		name     string
+		query    []byte
+		qtype    dnsmessage.Type
+		response []byte
+		ips      []string
+		ttl      time.Duration
+		err      error
+	}{
+		{"a", queryA, dnsmessage.TypeA, answered, []string{"192.0.2.1"}, 300 * time.Second, nil},
+		{"cname chain", queryA, dnsmessage.TypeA, buildResponse(t, queryA, dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			cnameRecord("example.com.", 60, "www.example.net."),
+			aRecord("www.example.net.", 300, "192.0.2.1"),
+			aRecord("www.example.net.", 300, "192.0.2.2"),
+		}, nil), []string{"192.0.2.1", "192.0.2.2"}, 60 * time.Second, nil},
+		{"aaaa only", queryAAAA, dnsmessage.TypeAAAA, buildResponse(t, queryAAAA, dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			aRecord("example.com.", 300, "192.0.2.1"),
+			aaaaRecord("example.com.", 120, "2001:db8::1"),
+		}, nil), []string{"2001:db8::1"}, 120 * time.Second, nil},
+		{"nxdomain with soa", queryA, dnsmessage.TypeA, buildResponse(t, queryA, dnsmessage.RCodeNameError, nil,
+			[]dnsmessage.Resource{soaRecord("example.com.", 3600, 900)}), nil, 900 * time.Second, nil},
+		{"empty with short soa", queryA, dnsmessage.TypeA, buildResponse(t, queryA, dnsmessage.RCodeSuccess, nil,
+			[]dnsmessage.Resource{soaRecord("example.com.", 10, 900)}), nil, 10 * time.Second, nil},
+		{"empty without soa", queryA, dnsmessage.TypeA, buildResponse(t, queryA, dnsmessage.RCodeSuccess, nil, nil), nil, negativeTTL, nil},
+		{"servfail", queryA, dnsmessage.TypeA, buildResponse(t, queryA, dnsmessage.RCodeServerFailure, nil, nil), nil, 0,
+			E.ErrDNSRcode(dnsmessage.RCodeServerFailure.String())},
+		{"other id", queryA, dnsmessage.TypeA, otherID, nil, 0, E.ErrInvalidDNSResponse},
+		{"not a response", queryA, dnsmessage.TypeA, notResponse, nil, 0, E.ErrInvalidDNSResponse},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ips, ttl, err := parseResponse(tc.response, tc.query, tc.qtype)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got error %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ips) != len(tc.ips) {
+				t.Fatalf("got %v, want %v", ips, tc.ips)
+			}
+			for i, ip := range ips {
+				if ip.String() != tc.ips[i] {
+					t.Errorf("got %v, want %v", ips, tc.ips)
+				}
+			}
+			if ttl != tc.ttl {
+				t.Errorf("got ttl %v, want %v", ttl, tc.ttl)
+			}
+		})
+	}
+}
+
+func TestParseResponseMalformed(t *testing.T) {
+	query, err := buildQuery("example.com.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := buildResponse(t, query, dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+		cnameRecord("example.com.", 60, "www.example.net."),
+		aRecord("www.example.net.", 300, "192.0.2.1"),
+	}, nil)
+	// records are counted in the header, so every truncation of a response has to be rejected.
+	for n := 0; n < len(response); n++ {
+		if _, _, err := parseResponse(response[:n], query, dnsmessage.TypeA); err == nil {
+			t.Errorf("response truncated to %d of %d bytes was accepted", n, len(response))
+		}
+	}
+	if _, _, err := parseResponse(loopingResponse(query), query, dnsmessage.TypeA); err == nil {
+		t.Error("response with a compression loop was accepted")
+	}
+}
+
+func TestIsTruncated(t *testing.T) {
+	query, err := buildQuery("example.com.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := buildResponse(t, query, dnsmessage.RCodeSuccess, nil, nil)
+	if isTruncated(response) {
+		t.Error("response is reported truncated")
+	}
+	response[2] |= 0x02
+	if !isTruncated(response) {
+		t.Error("truncated response isn't reported")
+	}
+	if isTruncated(response[:2]) {
+		t.Error("message without flags is reported truncated")
+	}
+}
+
+func TestCachingResolver(t *testing.T) {
+	var exchanges atomic.Int32
+	resolver := &cachingResolver{
+		exchange: func(ctx context.Context, query []byte) ([]byte, error) {
+			exchanges.Add(1)
+			var parser dnsmessage.Parser
+			if _, err := parser.Start(query); err != nil {
+				return nil, err
+			}
+			question, err := parser.Question()
+			if err != nil {
+				return nil, err
+			}
+			if question.Type == dnsmessage.TypeAAAA {
+				return buildResponse(t, query, dnsmessage.RCodeSuccess, []dnsmessage.Resource{aaaaRecord(question.Name.String(), 300, "2001:db8::1")}, nil), nil
+			}
+			return buildResponse(t, query, dnsmessage.RCodeSuccess, []dnsmessage.Resource{aRecord(question.Name.String(), 300, "192.0.2.1")}, nil), nil
+		},
+		cache: newCache(),
+	}
+	for i := 0; i < 2; i++ {
+		ips, err := resolver.LookupIP(context.Background(), "Example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 2 || ips[0].String() != "2001:db8::1" || ips[1].String() != "192.0.2.1" {
+			t.Fatalf("got %v", ips)
+		}
+	}
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("got %d exchanges, want 2 as answers are cached", n)
+	}
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+const (
+	maxMessageSize     = 65535
+	dnsMessageMIMEType = "application/dns-message"
+
+	// paramBootstrap in the query of a DNS over HTTPS URL is the address of its server.
+	paramBootstrap = "bootstrap"
+)
+
+// exchangeFunc sends a query to the server and returns its response.
+type exchangeFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+func newDNSExchange(network string, server string) exchangeFunc {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		response, err := exchangeConn(ctx, network, server, query)
+		if err != nil {
+			return nil, err
+		}
+		if network == "udp" && isTruncated(response) {
+			// answer doesn't fit in a datagram. ask again over TCP.
+			return exchangeConn(ctx, "tcp", server, query)
+		}
+		return response, nil
+	}
+}
+
+func exchangeConn(ctx context.Context, network string, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		// messages over streams are prefixed with their length.
+		message := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(message, uint16(len(query)))
+		copy(message[2:], query)
+		if _, err = conn.Write(message); err != nil {
+			return nil, err
+		}
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err = io.ReadFull(conn, response); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// datagrams with other ids are stray responses. they are ignored.
+		if n >= 2 && bytes.Equal(buf[:2], query[:2]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func isTruncated(message []byte) bool {
+	return len(message) > 2 && message[2]&0x02 != 0
+}
+
+// newDoHExchange sends queries to a DNS over HTTPS server, as described in RFC 8484.
+func newDoHExchange(u *url.URL) (exchangeFunc, error) {
+	query := u.Query()
+	bootstrap := query.Get(paramBootstrap)
+	query.Del(paramBootstrap)
+	endpointURL := *u
+	endpointURL.RawQuery = query.Encode()
+	endpoint := endpointURL.String()
+
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		if bootstrap == "" {
+			return nil, errors.ErrMissingPart("resolver bootstrap address")
+		}
+		if ip = net.ParseIP(bootstrap); ip == nil {
+			return nil, errors.ErrInvalidSyntax
+		}
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	server := net.JoinHostPort(ip.String(), port)
+	var dialer net.Dialer
+	client := &http.Client{
+		Transport: &http.Transport{
+			// TLS is still verified against the name in the URL.
+			DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", dnsMessageMIMEType)
+		request.Header.Set("Accept", dnsMessageMIMEType)
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, errors.ErrInvalidDNSResponse
+		}
+		return io.ReadAll(io.LimitReader(response.Body, maxMessageSize))
+	}, nil
+}
@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+const defaultPort = "53"
+
+// Resolver looks up addresses of hosts.
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// System resolves using resolver of the operating system.
+var System Resolver = systemResolver{}
+
+var resolvers = make(map[string]Resolver)
+var resolversMu sync.Mutex
+
+// Get returns the resolver for addr, which may be "udp://1.1.1.1:53", "tcp://1.1.1.1:53" or a DNS over HTTPS URL like
+// "https://1.1.1.1/dns-query". DNS over HTTPS servers given by name need the address to reach them at, as in
+// "https://dns.google/dns-query?bootstrap=8.8.8.8", so that looking them up doesn't leak to the system's resolver.
+// resolvers are shared, so that all dials using the same one benefit from its cache. empty addr or "system" refer to
+// the System resolver.
+func Get(addr string) (Resolver, error) {
+	if addr == "" || addr == "system" {
+		return System, nil
+	}
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	if resolver, found := resolvers[addr]; found {
+		return resolver, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	var exchange exchangeFunc
+	switch strings.ToLower(u.Scheme) {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, errors.ErrMissingPart("resolver address")
+		}
+		server := u.Host
+		if u.Port() == "" {
+			server = net.JoinHostPort(u.Hostname(), defaultPort)
+		}
+		exchange = newDNSExchange(strings.ToLower(u.Scheme), server)
+	case "https":
+		exchange, err = newDoHExchange(u)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.ErrUnsupportedScheme
+	}
+	resolver := &cachingResolver{exchange: exchange, cache: newCache()}
+	resolvers[addr] = resolver
+	return resolver, nil
+}
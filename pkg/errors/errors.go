@@ -1,6 +1,9 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"strconv"
+)
 
 var (
 	ErrNoPortDefined     = errors.New("port not defined")
@@ -8,6 +11,25 @@ var (
 	ErrUnsupportedScheme = errors.New("unsupported scheme")
 	ErrOpNotSupported    = errors.New("unsupported operation")
 	ErrInvalidSyntax     = errors.New("invalid syntax")
+
+	ErrCompressionLevelOutOfRange = errors.New("compression level out of range")
+	ErrNotDirectory               = errors.New("not a directory")
+	ErrInvalidProxyHeader         = errors.New("invalid PROXY protocol header")
+	ErrNotSocket                  = errors.New("file exists and is not a socket")
+	ErrSocketInUse                = errors.New("socket is in use")
+	ErrNotBalanceable             = errors.New("only dialable remotes can be balanced")
+	ErrNoHealthyRemote            = errors.New("no healthy remote")
+	ErrNoAddress                  = errors.New("no address found")
+	ErrInvalidDNSResponse         = errors.New("invalid DNS response")
+	ErrNoOriginalDestination      = errors.New("original destination of connection is unknown")
+	ErrSocks5Handshake            = errors.New("SOCKS5 handshake failed")
+	ErrSocks5AuthFailed           = errors.New("SOCKS5 authentication failed")
+	ErrTooManyConnections         = errors.New("too many connections")
+	ErrTooManyConnectionsFromIP   = errors.New("too many connections from address")
+	ErrAcceptRateExceeded         = errors.New("accept rate exceeded")
+	ErrQuotaExhausted             = errors.New("quota exhausted")
+	ErrAdminNotLocal              = errors.New("admin API is only served on unix sockets and loopback addresses")
+	ErrStdioInUse                 = errors.New("stdio is already in use")
 )
 
 type ErrMissingPart string
@@ -17,3 +39,27 @@ func (e ErrMissingPart) Error() string {
 }
 
 var _ error = ErrMissingPart("")
+
+type ErrUnhealthyStatus string
+
+func (e ErrUnhealthyStatus) Error() string {
+	return "unhealthy status: " + string(e)
+}
+
+var _ error = ErrUnhealthyStatus("")
+
+type ErrSocks5Reply byte
+
+func (e ErrSocks5Reply) Error() string {
+	return "SOCKS5 request failed with reply code " + strconv.Itoa(int(e))
+}
+
+var _ error = ErrSocks5Reply(0)
+
+type ErrDNSRcode string
+
+func (e ErrDNSRcode) Error() string {
+	return "DNS query failed: " + string(e)
+}
+
+var _ error = ErrDNSRcode("")
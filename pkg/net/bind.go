@@ -0,0 +1,182 @@
+package net
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamTCPBind      = "tcp.bind"
+	ParamTCPInterface = "tcp.interface"
+	ParamTCPMark      = "tcp.mark"
+	ParamTCPReusePort = "tcp.reuseport"
+	ParamTCPFastOpen  = "tcp.fastopen"
+	ParamTCPNoDelay   = "tcp.nodelay"
+	ParamTCPKeepAlive = "tcp.keepalive"
+	ParamTCPBacklog   = "tcp.backlog"
+
+	defaultFastOpenQueueLength = 256
+)
+
+// socketOptions are set on sockets before they connect or listen.
+type socketOptions struct {
+	iface string
+	mark  int
+	// for listeners only. dialers have no port to share.
+	reusePort bool
+	// length of the queue of pending TCP Fast Open requests for listeners. any positive number enables it for dialers.
+	fastOpen int
+	// lets listeners accept connections destined to any address, as redirected by TPROXY.
+	transparent bool
+}
+
+// parseSocketOptions parses options common to dialers and listeners.
+func parseSocketOptions(params url.Values) (socketOptions, error) {
+	opts := socketOptions{
+		iface: utils.StringFromParameters(params, ParamTCPInterface, ""),
+	}
+	if mark, found := utils.GetParameter(params, ParamTCPMark); found && mark != "" {
+		parsed, err := strconv.ParseUint(mark, 0, 32)
+		if err != nil {
+			return opts, err
+		}
+		opts.mark = int(parsed)
+	}
+	if fastOpen, found := utils.GetParameter(params, ParamTCPFastOpen); found && fastOpen != "" {
+		if enabled, err := utils.ParseBool(fastOpen); err == nil {
+			if enabled {
+				opts.fastOpen = defaultFastOpenQueueLength
+			}
+		} else {
+			queueLength, err := strconv.Atoi(fastOpen)
+			if err != nil {
+				return opts, err
+			}
+			opts.fastOpen = queueLength
+		}
+	}
+	return opts, nil
+}
+
+func (o socketOptions) empty() bool {
+	return o.iface == "" && o.mark == 0 && !o.reusePort && o.fastOpen <= 0 && !o.transparent
+}
+
+func (o socketOptions) control(listener bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, _ string, c syscall.RawConn) error {
+		var err error
+		controlErr := c.Control(func(fd uintptr) {
+			err = setBindOptions(fd, o, strings.HasPrefix(network, "tcp"), listener)
+		})
+		if controlErr != nil {
+			return controlErr
+		}
+		return err
+	}
+}
+
+// NewNetDialer creates a dialer for network ("tcp" or "udp"), whose sockets originate from the address in "tcp.bind",
+// are bound to the interface in "tcp.interface" and carry the firewall mark in "tcp.mark", for policy routing. TCP
+// options in "tcp.fastopen" and "tcp.keepalive" are applied as well.
+func NewNetDialer(network string, params url.Values) (*net.Dialer, error) {
+	dialer := &net.Dialer{}
+	if bind, found := utils.GetParameter(params, ParamTCPBind); found && bind != "" {
+		ip := net.ParseIP(bind)
+		if ip == nil {
+			return nil, errors.ErrInvalidSyntax
+		}
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	if keepalive := utils.DurationFromParameters(params, ParamTCPKeepAlive, 0); keepalive > 0 {
+		dialer.KeepAlive = keepalive
+	}
+	opts, err := parseSocketOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.empty() {
+		dialer.Control = opts.control(false)
+	}
+	return dialer, nil
+}
+
+// listenTCPSocket listens on host, with options in "tcp.reuseport", "tcp.fastopen", "tcp.nodelay", "tcp.keepalive" and
+// "tcp.backlog" applied.
+func listenTCPSocket(host string, params url.Values, transparent bool) (net.Listener, error) {
+	config := &net.ListenConfig{}
+	if keepalive := utils.DurationFromParameters(params, ParamTCPKeepAlive, 0); keepalive > 0 {
+		config.KeepAlive = keepalive
+	}
+	opts, err := parseSocketOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	opts.reusePort = utils.BoolFromParameters(params, ParamTCPReusePort, false)
+	opts.transparent = transparent
+	if !opts.empty() {
+		config.Control = opts.control(true)
+	}
+	listener, err := config.Listen(context.Background(), "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if backlog := utils.IntegerFromParameters(params, ParamTCPBacklog, 0); backlog != 0 {
+		if backlog < 0 || backlog > 65535 {
+			_ = listener.Close()
+			return nil, errors.ErrBacklogOutOfRange
+		}
+		if err = setListenBacklog(listener.(*net.TCPListener), backlog); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	if !utils.BoolFromParameters(params, ParamTCPNoDelay, true) {
+		listener = &noDelayListener{Listener: listener}
+	}
+	return listener, nil
+}
+
+// setListenBacklog calls listen() again on the socket, which updates length of its queue of pending connections.
+func setListenBacklog(listener *net.TCPListener, backlog int) error {
+	rawConn, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = rawConn.Control(func(fd uintptr) {
+		listenErr = listenSocket(fd, backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
+
+// noDelayListener disables TCP_NODELAY, which Go enables by default, on accepted connections.
+type noDelayListener struct {
+	net.Listener
+}
+
+func (l *noDelayListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(false)
+	}
+	return conn, nil
+}
+
+var _ net.Listener = &noDelayListener{}
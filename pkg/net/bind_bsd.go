@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package net
+
+import (
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// only SO_REUSEPORT is supported here. binding to interfaces, marking packets, TCP Fast Open and transparent proxying
+// are Linux only.
+func setBindOptions(fd uintptr, opts socketOptions, _ bool, _ bool) error {
+	if opts.iface != "" || opts.mark != 0 || opts.fastOpen > 0 || opts.transparent {
+		return errors.ErrOpNotSupported
+	}
+	if opts.reusePort {
+		return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}
+	return nil
+}
+
+func listenSocket(fd uintptr, backlog int) error {
+	return unix.Listen(int(fd), backlog)
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package net
+
+import "golang.org/x/sys/unix"
+
+func setBindOptions(fd uintptr, opts socketOptions, tcp bool, listener bool) error {
+	if opts.iface != "" {
+		if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, opts.iface); err != nil {
+			return err
+		}
+	}
+	if opts.mark != 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, opts.mark); err != nil {
+			return err
+		}
+	}
+	if opts.reusePort {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	if opts.transparent {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+			return err
+		}
+		// dual-stack sockets need it for IPv6 too. it fails on IPv4 only sockets, which is fine.
+		_ = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+	}
+	if tcp && opts.fastOpen > 0 {
+		if listener {
+			return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, opts.fastOpen)
+		}
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	}
+	return nil
+}
+
+func listenSocket(fd uintptr, backlog int) error {
+	return unix.Listen(int(fd), backlog)
+}
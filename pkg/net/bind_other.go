@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package net
+
+import "github.com/hadi77ir/wsproxy/pkg/errors"
+
+// binding to interfaces, marking packets and other socket options are not supported here.
+func setBindOptions(_ uintptr, _ socketOptions, _ bool, _ bool) error {
+	return errors.ErrOpNotSupported
+}
+
+func listenSocket(_ uintptr, _ int) error {
+	return errors.ErrOpNotSupported
+}
@@ -0,0 +1,22 @@
+package net
+
+import (
+	"net"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// CloseWrite shuts down the writing side of conn, so that the peer reads EOF while conn can still be read from. TCP
+// and Unix connections send FIN, TLS ones send close_notify, and WebSocket ones send an empty message when the peer
+// supports it. wrappers which don't change the data pass it on to the connection they wrap, instead of unwrapping
+// being done here, as half-closing a connection carrying TLS or WebSocket frames would corrupt them.
+func CloseWrite(conn net.Conn) error {
+	if c, ok := conn.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return errors.ErrOpNotSupported
+}
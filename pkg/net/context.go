@@ -0,0 +1,22 @@
+package net
+
+import (
+	"context"
+	"net"
+)
+
+type incomingConnKey struct{}
+
+// ContextWithIncoming returns a context carrying the accepted connection for which a dial is being made.
+// dialers use it to learn about the original client, e.g. to send PROXY protocol headers.
+func ContextWithIncoming(ctx context.Context, incoming net.Conn) context.Context {
+	return context.WithValue(ctx, incomingConnKey{}, incoming)
+}
+
+// IncomingFromContext returns the accepted connection stored in context, or nil if there is none.
+func IncomingFromContext(ctx context.Context) net.Conn {
+	if incoming, ok := ctx.Value(incomingConnKey{}).(net.Conn); ok {
+		return incoming
+	}
+	return nil
+}
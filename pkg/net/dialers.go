@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"net"
 	"net/url"
 	"strings"
@@ -16,8 +17,8 @@ import (
 
 const defaultDialTimeout = time.Duration(5) * time.Second
 
-type DialFunc func(addr string, transportParams url.Values) (net.Conn, error)
-type TransportDialFunc func(host string, transportParams url.Values) (net.Conn, error)
+type DialFunc func(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error)
+type TransportDialFunc func(ctx context.Context, host string, transportParams url.Values) (net.Conn, error)
 
 var Dialers = &registry.Registry[DialFunc]{}
 
@@ -26,9 +27,12 @@ func registerDialers() {
 	Dialers.Register("tls", dialTLS)
 	Dialers.Register("ws", newWSDialer(dialTCPTransport, "ws"))
 	Dialers.Register("wss", newWSDialer(dialTLSTransport, "wss"))
+	Dialers.Register("unix", dialUnix)
+	Dialers.Register("udp", dialUDP)
+	Dialers.Register("ws+unix", newWSDialer(dialUnixTransport, "ws+unix"))
 }
 
-func dialTCP(addr string, transportParams url.Values) (net.Conn, error) {
+func dialTCP(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
@@ -37,31 +41,34 @@ func dialTCP(addr string, transportParams url.Values) (net.Conn, error) {
 	if !strings.EqualFold(u.Scheme, "tcp") {
 		return nil, errors.ErrUnsupportedScheme
 	}
-	return dialTCPTransport(u.Host, transportParams)
+	return dialTCPTransport(ctx, u.Host, transportParams)
 }
 
-func dialTCPTransport(host string, transportParams url.Values) (net.Conn, error) {
-	conn, err := net.DialTimeout("tcp", host, utils.DurationFromParameters(transportParams, "tcp.dial_timeout", defaultDialTimeout))
+func dialTCPTransport(ctx context.Context, host string, transportParams url.Values) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, utils.DurationFromParameters(transportParams, "tcp.dial_timeout", defaultDialTimeout))
+	defer cancel()
+	dialer, err := NewNetDialer("tcp", transportParams)
 	if err != nil {
 		return nil, err
 	}
-	// apply keep alive
-	keepalive := utils.DurationFromParameters(transportParams, "tcp.keepalive", 0)
-	if keepalive > 0 {
-		err = conn.(*net.TCPConn).SetKeepAlive(true)
-		if err != nil {
-			_ = conn.Close()
-			return nil, err
-		}
-		err = conn.(*net.TCPConn).SetKeepAlivePeriod(keepalive)
-		if err != nil {
-			_ = conn.Close()
-			return nil, err
+	conn, err := dialHappyEyeballs(ctx, dialer, "tcp", host, transportParams)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.BoolFromParameters(transportParams, ParamTCPNoDelay, true) {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.SetNoDelay(false)
 		}
 	}
+	// PROXY protocol header has to be the first thing on the wire, before any TLS or WebSocket handshake.
+	err = sendProxyHeader(conn, IncomingFromContext(ctx), transportParams)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
 	return conn, nil
 }
-func dialTLS(addr string, transportParams url.Values) (net.Conn, error) {
+func dialTLS(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
@@ -71,21 +78,21 @@ func dialTLS(addr string, transportParams url.Values) (net.Conn, error) {
 	if !strings.EqualFold(u.Scheme, "tls") {
 		return nil, errors.ErrUnsupportedScheme
 	}
-	return dialTLSTransport(u.Host, transportParams)
+	return dialTLSTransport(ctx, u.Host, transportParams)
 }
-func dialTLSTransport(host string, transportParams url.Values) (net.Conn, error) {
+func dialTLSTransport(ctx context.Context, host string, transportParams url.Values) (net.Conn, error) {
 	config, helloId, err := crypt.ParseUTLS(transportParams, true)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := dialTCPTransport(host, transportParams)
+	conn, err := dialTCPTransport(ctx, host, transportParams)
 	if err != nil {
 		return nil, err
 	}
 
 	tlsConn := utls.UClient(conn, config, helloId)
-	err = tlsConn.Handshake()
+	err = tlsConn.HandshakeContext(ctx)
 	if err != nil {
 		_ = conn.Close()
 		return nil, err
@@ -94,7 +101,7 @@ func dialTLSTransport(host string, transportParams url.Values) (net.Conn, error)
 }
 
 func newWSDialer(transportDialer TransportDialFunc, scheme string) DialFunc {
-	return func(addr string, transportParams url.Values) (net.Conn, error) {
+	return func(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
 		u, err := url.Parse(addr)
 		if err != nil {
 			return nil, err
@@ -105,15 +112,22 @@ func newWSDialer(transportDialer TransportDialFunc, scheme string) DialFunc {
 			return nil, errors.ErrUnsupportedScheme
 		}
 
-		baseConn, err := transportDialer(addDefaultPort(u.Host, scheme), transportParams)
+		opts, err := wsconn.ParseOptions(transportParams, false)
+		if err != nil {
+			return nil, err
+		}
+
+		host, wsAddr := AddDefaultPort(u.Host, scheme), addr
+		if isUnixWSScheme(scheme) {
+			host, wsAddr = splitUnixWSAddr(addr, u, transportParams)
+		}
+
+		baseConn, err := transportDialer(ctx, host, transportParams)
 		if err != nil {
 			return nil, err
 		}
 
-		conn, err := wsconn.WSClient(addr,
-			baseConn,
-			utils.IntegerFromParameters(transportParams, "ws.read_buffer", 0),
-			utils.IntegerFromParameters(transportParams, "ws.write_buffer", 0))
+		conn, err := wsconn.WSClient(wsAddr, baseConn, opts)
 
 		if err != nil {
 			_ = baseConn.Close()
@@ -123,7 +137,8 @@ func newWSDialer(transportDialer TransportDialFunc, scheme string) DialFunc {
 	}
 }
 
-func addDefaultPort(host string, scheme string) string {
+// AddDefaultPort appends the default port of "ws" or "wss" scheme to host, unless it has one.
+func AddDefaultPort(host string, scheme string) string {
 	if !strings.ContainsAny(host, ":") {
 		switch scheme {
 		case "ws":
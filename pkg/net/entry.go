@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"net"
 	"net/url"
 	"strings"
@@ -8,7 +9,7 @@ import (
 	"github.com/hadi77ir/wsproxy/pkg/errors"
 )
 
-type PrimedDialerFunc func() (net.Conn, error)
+type PrimedDialerFunc func(ctx context.Context) (net.Conn, error)
 
 func init() {
 	registerDialers()
@@ -22,7 +23,9 @@ func transformParams(uQ, tP url.Values) (filteredParams url.Values, transportPar
 		transportParams[k] = v
 	}
 	for k, v := range uQ {
-		if strings.HasPrefix(k, "tcp.") || strings.HasPrefix(k, "tls.") || strings.HasPrefix(k, "ws.") {
+		if strings.HasPrefix(k, "tcp.") || strings.HasPrefix(k, "tls.") || strings.HasPrefix(k, "ws.") ||
+			strings.HasPrefix(k, "unix.") || strings.HasPrefix(k, "udp.") || strings.HasPrefix(k, "dial.") ||
+			strings.HasPrefix(k, "pool.") || strings.HasPrefix(k, "limit.") || strings.HasPrefix(k, "conn.") {
 			transportParams[k] = v
 		} else {
 			filteredParams[k] = v
@@ -31,14 +34,27 @@ func transformParams(uQ, tP url.Values) (filteredParams url.Values, transportPar
 	return
 }
 
-func ListenURL(addr string, transportParams url.Values) (net.Listener, error) {
+// SplitParams moves transport parameters found in query of addr to transportParams. returned address only keeps the
+// rest of the query.
+func SplitParams(addr string, transportParams url.Values) (string, url.Values, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	filteredParams, newTransportParams := transformParams(u.Query(), transportParams)
 	u.RawQuery = filteredParams.Encode()
-	addr = u.String()
+	return u.String(), newTransportParams, nil
+}
+
+func ListenURL(addr string, transportParams url.Values) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	addr, newTransportParams, err := SplitParams(addr, transportParams)
+	if err != nil {
+		return nil, err
+	}
 
 	if listenFunc, found := Listeners.Get(u.Scheme); found {
 		return listenFunc(addr, newTransportParams)
@@ -46,17 +62,18 @@ func ListenURL(addr string, transportParams url.Values) (net.Listener, error) {
 	return nil, errors.ErrUnsupportedScheme
 }
 
-func DialURL(addr string, transportParams url.Values) (net.Conn, error) {
+func DialURL(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
 	}
-	filteredParams, newTransportParams := transformParams(u.Query(), transportParams)
-	u.RawQuery = filteredParams.Encode()
-	addr = u.String()
+	addr, newTransportParams, err := SplitParams(addr, transportParams)
+	if err != nil {
+		return nil, err
+	}
 
 	if dialFunc, found := Dialers.Get(u.Scheme); found {
-		return dialFunc(addr, newTransportParams)
+		return dialFunc(ctx, addr, newTransportParams)
 	}
 	return nil, errors.ErrUnsupportedScheme
 }
@@ -66,13 +83,14 @@ func CreateDialer(addr string, transportParams url.Values) (PrimedDialerFunc, er
 	if err != nil {
 		return nil, err
 	}
-	filteredParams, newTransportParams := transformParams(u.Query(), transportParams)
-	u.RawQuery = filteredParams.Encode()
-	addr = u.String()
+	addr, newTransportParams, err := SplitParams(addr, transportParams)
+	if err != nil {
+		return nil, err
+	}
 
 	if dialFunc, found := Dialers.Get(u.Scheme); found {
-		return func() (net.Conn, error) {
-			return dialFunc(addr, newTransportParams)
+		return func(ctx context.Context) (net.Conn, error) {
+			return dialFunc(ctx, addr, newTransportParams)
 		}, nil
 	}
 	return nil, errors.ErrUnsupportedScheme
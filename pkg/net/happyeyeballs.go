@@ -0,0 +1,165 @@
+package net
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/dns"
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamTCPPrefer   = "tcp.prefer"
+	ParamTCPResolver = "tcp.resolver"
+
+	preferIPv4 = "ipv4"
+	preferIPv6 = "ipv6"
+
+	// connectionAttemptDelay is the recommended delay between attempts, from RFC 8305.
+	connectionAttemptDelay = time.Duration(250) * time.Millisecond
+)
+
+// ResolveHost looks up addresses of host using the resolver set in "tcp.resolver", sorted in the order they should be
+// tried. IPv6 addresses go first, unless "tcp.prefer" says otherwise, and families are interleaved after that.
+func ResolveHost(ctx context.Context, host string, params url.Values) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	resolver, err := dns.Get(utils.StringFromParameters(params, ParamTCPResolver, ""))
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.ErrNoAddress
+	}
+	prefer := strings.ToLower(utils.StringFromParameters(params, ParamTCPPrefer, preferIPv6))
+	if prefer != preferIPv4 && prefer != preferIPv6 {
+		return nil, errors.ErrInvalidSyntax
+	}
+	return sortAddresses(ips, prefer == preferIPv4), nil
+}
+
+func sortAddresses(ips []net.IP, ipv4First bool) []net.IP {
+	var preferred, others []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == ipv4First {
+			preferred = append(preferred, ip)
+		} else {
+			others = append(others, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(preferred) || i < len(others); i++ {
+		if i < len(preferred) {
+			sorted = append(sorted, preferred[i])
+		}
+		if i < len(others) {
+			sorted = append(sorted, others[i])
+		}
+	}
+	return sorted
+}
+
+// dialHappyEyeballs dials host, as described in RFC 8305. attempts to addresses of host are started one after another,
+// each one as soon as the previous fails or after a short delay, and the first one to succeed wins.
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, network string, host string,
+	params url.Values) (net.Conn, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(hostname) != nil {
+		return dialer.DialContext(ctx, network, host)
+	}
+	ips, err := ResolveHost(ctx, hostname, params)
+	if err != nil {
+		return nil, err
+	}
+	return DialAddresses(ctx, dialer, network, ips, port)
+}
+
+// DialAddresses dials port on each of ips in turn, the way dialHappyEyeballs does, e.g. for addresses resolved with
+// ResolveHost.
+func DialAddresses(ctx context.Context, dialer *net.Dialer, network string, ips []net.IP, port string) (net.Conn, error) {
+	switch len(ips) {
+	case 0:
+		return nil, errors.ErrNoAddress
+	case 1:
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered, so that attempts finishing after the race is over don't block.
+	results := make(chan dialResult, len(ips))
+	next, pending := 0, 0
+	startAttempt := func() {
+		addr := net.JoinHostPort(ips[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	var firstErr error
+	startAttempt()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLateConns(results, pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ips) {
+				startAttempt()
+				resetTimer(timer, connectionAttemptDelay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				startAttempt()
+				timer.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+func closeLateConns(results <-chan dialResult, pending int) {
+	for ; pending > 0; pending-- {
+		if r := <-results; r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+}
+
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
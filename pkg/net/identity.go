@@ -0,0 +1,47 @@
+package net
+
+import (
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+type userConn interface {
+	User() string
+}
+
+type tlsConn interface {
+	Handshake() error
+	ConnectionState() utls.ConnectionState
+}
+
+type netConnUnwrapper interface {
+	NetConn() net.Conn
+}
+
+// AuthenticatedUser returns name of the user who has been authenticated on the transport of the given connection,
+// such as common name of the TLS client certificate. returns empty string if there is none.
+func AuthenticatedUser(conn net.Conn) string {
+	for conn != nil {
+		if c, ok := conn.(userConn); ok {
+			if user := c.User(); user != "" {
+				return user
+			}
+		}
+		if c, ok := conn.(tlsConn); ok {
+			if err := c.Handshake(); err != nil {
+				return ""
+			}
+			if certs := c.ConnectionState().PeerCertificates; len(certs) > 0 {
+				return certs[0].Subject.CommonName
+			}
+			return ""
+		}
+		unwrapper, ok := conn.(netConnUnwrapper)
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+	return ""
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/hadi77ir/go-registry"
 	"github.com/hadi77ir/wsproxy/pkg/crypt"
 	"github.com/hadi77ir/wsproxy/pkg/errors"
-	"github.com/hadi77ir/wsproxy/pkg/utils"
 	"github.com/hadi77ir/wsproxy/pkg/wsconn"
 	utls "github.com/refraction-networking/utls"
 	"net"
@@ -32,6 +31,12 @@ func registerListeners() {
 	Listeners.Register("tls", listenTLS)
 	Listeners.Register("ws", newWSListener(listenTCP2))
 	Listeners.Register("wss", newWSListener(listenTLS2))
+	Listeners.Register("unix", listenUnix)
+	Listeners.Register("udp", listenUDP)
+	Listeners.Register("stdio", listenStdio)
+	Listeners.Register("ws+unix", newWSListener(listenUnixTransport))
+	Listeners.Register("redir", newTransparentListener("redir", false))
+	Listeners.Register("tproxy", newTransparentListener("tproxy", true))
 }
 
 func listenTCP(addr string, transportParams url.Values) (net.Listener, error) {
@@ -49,7 +54,16 @@ func listenTCP(addr string, transportParams url.Values) (net.Listener, error) {
 }
 
 func listenTCP2(host string, params url.Values) (net.Listener, error) {
-	return net.Listen("tcp", host)
+	listener, err := listenTCPSocket(host, params, false)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapProxyProtocolListener(listener, params)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return wrapped, nil
 }
 
 func listenTLS(addr string, transportParams url.Values) (net.Listener, error) {
@@ -72,7 +86,11 @@ func listenTLS2(host string, transportParams url.Values) (net.Listener, error) {
 		return nil, err
 	}
 
-	return utls.Listen("tcp", host, config)
+	listener, err := listenTCP2(host, transportParams)
+	if err != nil {
+		return nil, err
+	}
+	return utls.NewListener(listener, config), nil
 }
 
 func newWSListener(transportListen TransportListenFunc) ListenFunc {
@@ -82,16 +100,22 @@ func newWSListener(transportListen TransportListenFunc) ListenFunc {
 			return nil, err
 		}
 
-		listener, err := transportListen(u.Host, transportParams)
+		opts, err := wsconn.ParseOptions(transportParams, true)
+		if err != nil {
+			return nil, err
+		}
+
+		host, wsAddr := u.Host, u.String()
+		if isUnixWSScheme(u.Scheme) {
+			host, wsAddr = splitUnixWSAddr(addr, u, transportParams)
+		}
+
+		listener, err := transportListen(host, transportParams)
 		if err != nil {
 			return nil, err
 		}
 
-		wsListener, err := wsconn.WSServe(u.String(),
-			listenerBacklog,
-			listener,
-			utils.IntegerFromParameters(transportParams, "ws.read_buffer", 0),
-			utils.IntegerFromParameters(transportParams, "ws.write_buffer", 0))
+		wsListener, err := wsconn.WSServe(wsAddr, listenerBacklog, listener, opts)
 
 		if err != nil {
 			_ = listener.Close()
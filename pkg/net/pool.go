@@ -0,0 +1,173 @@
+package net
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamPoolSize = "pool.size"
+	ParamPoolTTL  = "pool.ttl"
+
+	defaultPoolTTL       = time.Duration(30) * time.Second
+	poolRetryInterval    = time.Second
+	poolMaxRetryInterval = time.Duration(30) * time.Second
+	minPoolSweepInterval = time.Second
+)
+
+type pooledConn struct {
+	conn    net.Conn
+	created time.Time
+}
+
+// Pool keeps a number of connections dialed ahead of time, so that they can be handed out without waiting for
+// transport handshakes. connections older than the TTL are discarded, as servers tend to close idle connections.
+type Pool struct {
+	dialer PrimedDialerFunc
+	size   int
+	ttl    time.Duration
+	conns  chan pooledConn
+	wake   chan struct{}
+}
+
+func NewPool(dialer PrimedDialerFunc, size int, ttl time.Duration) *Pool {
+	return &Pool{
+		dialer: dialer,
+		size:   size,
+		ttl:    ttl,
+		conns:  make(chan pooledConn, size),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// NewPoolFromParameters creates a pool as configured by "pool.size" and "pool.ttl" parameters. it returns nil if pool
+// is disabled, or if PROXY protocol headers are sent, as they depend on the incoming connection.
+func NewPoolFromParameters(dialer PrimedDialerFunc, params url.Values) *Pool {
+	size := utils.IntegerFromParameters(params, ParamPoolSize, 0)
+	if size <= 0 {
+		return nil
+	}
+	if mode, found := utils.GetParameter(params, ParamProxyProtocol); found && mode != "" {
+		return nil
+	}
+	return NewPool(dialer, size, utils.DurationFromParameters(params, ParamPoolTTL, defaultPoolTTL))
+}
+
+// Dial hands out a pooled connection, or dials a new one if none is available.
+func (p *Pool) Dial(ctx context.Context) (net.Conn, error) {
+	defer p.refill()
+	for {
+		select {
+		case pooled := <-p.conns:
+			if p.expired(pooled) {
+				_ = pooled.conn.Close()
+				continue
+			}
+			return pooled.conn, nil
+		default:
+			return p.dialer(ctx)
+		}
+	}
+}
+
+func (p *Pool) refill() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) expired(pooled pooledConn) bool {
+	return p.ttl > 0 && time.Since(pooled.created) > p.ttl
+}
+
+// Run keeps the pool filled until done is closed, then closes pooled connections.
+func (p *Pool) Run(done <-chan struct{}) {
+	defer p.drain()
+
+	sweepInterval := p.ttl / 2
+	if sweepInterval < minPoolSweepInterval {
+		sweepInterval = minPoolSweepInterval
+	}
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	retryInterval := poolRetryInterval
+	for {
+		failed := false
+		for len(p.conns) < p.size && ctx.Err() == nil {
+			conn, err := p.dialer(ctx)
+			if err != nil {
+				failed = true
+				break
+			}
+			retryInterval = poolRetryInterval
+			p.conns <- pooledConn{conn: conn, created: time.Now()}
+		}
+
+		if failed {
+			// the remote is likely down. wait a while before trying again.
+			timer := time.NewTimer(retryInterval)
+			if retryInterval *= 2; retryInterval > poolMaxRetryInterval {
+				retryInterval = poolMaxRetryInterval
+			}
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case <-p.wake:
+		case <-sweep.C:
+			p.sweep()
+		}
+	}
+}
+
+// sweep closes expired connections.
+func (p *Pool) sweep() {
+	for i := len(p.conns); i > 0; i-- {
+		select {
+		case pooled := <-p.conns:
+			if p.expired(pooled) {
+				_ = pooled.conn.Close()
+				continue
+			}
+			select {
+			case p.conns <- pooled:
+			default:
+				_ = pooled.conn.Close()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) drain() {
+	for {
+		select {
+		case pooled := <-p.conns:
+			_ = pooled.conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+// HAProxy PROXY protocol, as described in https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+
+const (
+	ParamProxyProtocol        = "tcp.proxy_protocol"
+	ParamProxyProtocolTrusted = "tcp.proxy_protocol_trusted"
+	ParamProxyProtocolTimeout = "tcp.proxy_protocol_timeout"
+
+	proxyProtocolAccept = "accept"
+	proxyProtocolSendV1 = "send:v1"
+	proxyProtocolSendV2 = "send:v2"
+
+	defaultProxyProtocolTimeout = time.Duration(5) * time.Second
+
+	proxyV1MaxLength = 107
+)
+
+var proxyV1Signature = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV2CommandLocal = 0x20
+	proxyV2CommandProxy = 0x21
+
+	proxyV2FamilyUnspec = 0x00
+	proxyV2FamilyTCP4   = 0x11
+	proxyV2FamilyTCP6   = 0x21
+	proxyV2FamilyUDP4   = 0x12
+	proxyV2FamilyUDP6   = 0x22
+)
+
+type proxyProtocolListener struct {
+	net.Listener
+	trusted *utils.TrustedPeers
+	timeout time.Duration
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted.Contains(conn.RemoteAddr()) {
+		// headers from untrusted sources are not parsed. connection is passed as-is.
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+var _ net.Listener = &proxyProtocolListener{}
+
+// proxyProtocolConn reads the header lazily, on first call to Read, Write, RemoteAddr or LocalAddr, so that a slow client
+// doesn't block the accept loop.
+type proxyProtocolConn struct {
+	net.Conn
+	once       sync.Once
+	reader     io.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	timeout    time.Duration
+	err        error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		reader := bufio.NewReader(c.Conn)
+		c.remoteAddr, c.localAddr, c.err = readProxyHeader(reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		c.reader = reader
+		if c.err != nil {
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) Write(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyProtocolConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+func (c *proxyProtocolConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &proxyProtocolConn{}
+
+func readProxyHeader(reader *bufio.Reader) (src net.Addr, dst net.Addr, err error) {
+	signature, err := reader.Peek(len(proxyV1Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(signature, proxyV1Signature) {
+		return readProxyHeaderV1(reader)
+	}
+	signature, err = reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(reader)
+	}
+	return nil, nil, errors.ErrInvalidProxyHeader
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, nil, errors.ErrInvalidProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.ErrInvalidProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.ErrInvalidProxyHeader
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, errors.ErrInvalidProxyHeader
+	}
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: int(parsedPort)}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, err
+	}
+	switch header[12] {
+	case proxyV2CommandLocal:
+		// health checks of the proxy itself. addresses of the connection are the real ones.
+		return nil, nil, nil
+	case proxyV2CommandProxy:
+	default:
+		return nil, nil, errors.ErrInvalidProxyHeader
+	}
+
+	var ipLen int
+	switch header[13] {
+	case proxyV2FamilyTCP4, proxyV2FamilyUDP4:
+		ipLen = net.IPv4len
+	case proxyV2FamilyTCP6, proxyV2FamilyUDP6:
+		ipLen = net.IPv6len
+	default:
+		// unix sockets and unspecified families carry no usable address.
+		return nil, nil, nil
+	}
+	if length < 2*ipLen+4 {
+		return nil, nil, errors.ErrInvalidProxyHeader
+	}
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return src, dst, nil
+}
+
+// tcpAddrOf converts an address to its IP and port form. returns nil if it's not an IP address.
+func tcpAddrOf(addr net.Addr) *net.TCPAddr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	case nil:
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+// buildProxyHeader creates a header describing a connection from src to dst. if any of the addresses is not an IP
+// address, the header will tell the receiver to use addresses of the connection itself.
+func buildProxyHeader(version string, src, dst net.Addr) []byte {
+	srcAddr := tcpAddrOf(src)
+	dstAddr := tcpAddrOf(dst)
+	known := srcAddr != nil && dstAddr != nil
+	ipv4 := known && srcAddr.IP.To4() != nil && dstAddr.IP.To4() != nil
+
+	if version == proxyProtocolSendV1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		// mixed families have to be expressed as IPv6, with IPv4 addresses mapped.
+		family, srcIP, dstIP := "TCP6", formatIPv6(srcAddr.IP), formatIPv6(dstAddr.IP)
+		if ipv4 {
+			family, srcIP, dstIP = "TCP4", srcAddr.IP.To4().String(), dstAddr.IP.To4().String()
+		}
+		return []byte("PROXY " + family + " " + srcIP + " " + dstIP + " " +
+			strconv.Itoa(srcAddr.Port) + " " + strconv.Itoa(dstAddr.Port) + "\r\n")
+	}
+
+	header := bytes.NewBuffer(make([]byte, 0, 16+36))
+	header.Write(proxyV2Signature)
+	if !known {
+		header.Write([]byte{proxyV2CommandLocal, proxyV2FamilyUnspec, 0, 0})
+		return header.Bytes()
+	}
+	family, srcIP, dstIP := byte(proxyV2FamilyTCP6), srcAddr.IP.To16(), dstAddr.IP.To16()
+	if ipv4 {
+		family, srcIP, dstIP = proxyV2FamilyTCP4, srcAddr.IP.To4(), dstAddr.IP.To4()
+	}
+	header.Write([]byte{proxyV2CommandProxy, family})
+	_ = binary.Write(header, binary.BigEndian, uint16(2*len(srcIP)+4))
+	header.Write(srcIP)
+	header.Write(dstIP)
+	_ = binary.Write(header, binary.BigEndian, uint16(srcAddr.Port))
+	_ = binary.Write(header, binary.BigEndian, uint16(dstAddr.Port))
+	return header.Bytes()
+}
+
+func formatIPv6(ip net.IP) string {
+	addr, _ := netip.AddrFromSlice(ip.To16())
+	return addr.String()
+}
+
+func wrapProxyProtocolListener(listener net.Listener, params url.Values) (net.Listener, error) {
+	mode, found := utils.GetParameter(params, ParamProxyProtocol)
+	if !found || mode == "" {
+		return listener, nil
+	}
+	if mode != proxyProtocolAccept {
+		return nil, errors.ErrInvalidSyntax
+	}
+	trusted, err := utils.TrustedPeersFromParameters(params, ParamProxyProtocolTrusted)
+	if err != nil {
+		return nil, err
+	}
+	// anyone could claim any address otherwise.
+	if trusted == nil {
+		return nil, errors.ErrMissingPart(ParamProxyProtocolTrusted)
+	}
+	return &proxyProtocolListener{
+		Listener: listener,
+		trusted:  trusted,
+		timeout:  utils.DurationFromParameters(params, ParamProxyProtocolTimeout, defaultProxyProtocolTimeout),
+	}, nil
+}
+
+// sendProxyHeader writes a header on a freshly dialed connection, describing the incoming connection which caused it.
+func sendProxyHeader(conn net.Conn, incoming net.Conn, params url.Values) error {
+	mode, found := utils.GetParameter(params, ParamProxyProtocol)
+	if !found || mode == "" {
+		return nil
+	}
+	if mode != proxyProtocolSendV1 && mode != proxyProtocolSendV2 {
+		return errors.ErrInvalidSyntax
+	}
+	var src, dst net.Addr
+	if incoming != nil {
+		src, dst = incoming.RemoteAddr(), incoming.LocalAddr()
+	}
+	_, err := conn.Write(buildProxyHeader(mode, src, dst))
+	return err
+}
@@ -0,0 +1,156 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+// proxyV2Header builds a version 2 header with the given command and family byte, followed by payload. length is that
+// of payload, unless it's given.
+func proxyV2Header(command, family byte, payload []byte, length ...int) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, command, family, 0, 0)
+	if len(length) > 0 {
+		binary.BigEndian.PutUint16(header[14:], uint16(length[0]))
+	} else {
+		binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	}
+	return append(header, payload...)
+}
+
+func proxyV2Addresses(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	payload := append(append([]byte{}, src...), dst...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4Addresses := proxyV2Addresses(net.IPv4(203, 0, 113, 7).To4(), net.IPv4(192, 0, 2, 1).To4(), 51234, 443)
+	ipv6Addresses := proxyV2Addresses(net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::1"), 51234, 443)
+	// a NOOP and an AUTHORITY TLV, which are skipped.
+	tlvs := []byte{0x04, 0x00, 0x02, 0x00, 0x00, 0x02, 0x00, 0x0b}
+	tlvs = append(tlvs, "example.com"...)
+
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		src   string
+		dst   string
+		err   error
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\r\n"), "203.0.113.7:51234", "192.0.2.1:443", nil},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\n"), "[2001:db8::7]:51234", "[2001:db8::1]:443", nil},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", "", nil},
+		{"v1 unknown with addresses", []byte("PROXY UNKNOWN ff:: ff:: 1 2\r\n"), "", "", nil},
+		{"v1 longest", []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+			"[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", nil},
+		// UNKNOWN lines are accepted whatever follows, as long as they fit.
+		{"v1 unknown longest", []byte("PROXY UNKNOWN " + strings.Repeat("A", proxyV1MaxLength-16) + "\r\n"), "", "", nil},
+		{"v1 oversized", []byte("PROXY UNKNOWN " + strings.Repeat("A", proxyV1MaxLength-15) + "\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 without end", []byte("PROXY " + strings.Repeat("A", 200)), "", "", E.ErrInvalidProxyHeader},
+		{"v1 truncated", []byte("PROXY TCP4 203.0.113.7 192.0"), "", "", io.EOF},
+		{"v1 without carriage return", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 missing fields", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 51234\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 extra fields", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 51234 443 1\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 unsupported family", []byte("PROXY UDP4 203.0.113.7 192.0.2.1 51234 443\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 invalid address", []byte("PROXY TCP4 203.0.113.256 192.0.2.1 51234 443\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 invalid port", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 65536 443\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v1 negative port", []byte("PROXY TCP4 203.0.113.7 192.0.2.1 -1 443\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v2 tcp4", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP4, ipv4Addresses), "203.0.113.7:51234", "192.0.2.1:443", nil},
+		{"v2 udp4", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyUDP4, ipv4Addresses), "203.0.113.7:51234", "192.0.2.1:443", nil},
+		{"v2 tcp6", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP6, ipv6Addresses), "[2001:db8::7]:51234", "[2001:db8::1]:443", nil},
+		{"v2 tlvs", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP4, append(ipv4Addresses, tlvs...)), "203.0.113.7:51234", "192.0.2.1:443", nil},
+		{"v2 local", proxyV2Header(proxyV2CommandLocal, proxyV2FamilyUnspec, nil), "", "", nil},
+		{"v2 local with addresses", proxyV2Header(proxyV2CommandLocal, proxyV2FamilyTCP4, ipv4Addresses), "", "", nil},
+		{"v2 unspec", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyUnspec, tlvs), "", "", nil},
+		{"v2 unix", proxyV2Header(proxyV2CommandProxy, 0x31, make([]byte, 216)), "", "", nil},
+		{"v2 unknown command", proxyV2Header(0x22, proxyV2FamilyTCP4, ipv4Addresses), "", "", E.ErrInvalidProxyHeader},
+		{"v2 unknown version", proxyV2Header(0x11, proxyV2FamilyTCP4, ipv4Addresses), "", "", E.ErrInvalidProxyHeader},
+		{"v2 short addresses", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP6, ipv4Addresses), "", "", E.ErrInvalidProxyHeader},
+		{"v2 truncated header", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP4, nil)[:14], "", "", io.ErrUnexpectedEOF},
+		{"v2 truncated payload", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP4, ipv4Addresses[:5], len(ipv4Addresses)), "", "", io.ErrUnexpectedEOF},
+		{"v2 oversized length", proxyV2Header(proxyV2CommandProxy, proxyV2FamilyTCP4, ipv4Addresses, 65535), "", "", io.ErrUnexpectedEOF},
+		{"no signature", []byte("GET / HTTP/1.1\r\n\r\n"), "", "", E.ErrInvalidProxyHeader},
+		{"v2 signature mismatch", []byte("\r\n\r\n\x00\r\nQUIT\r" + strings.Repeat("\x00", 8)), "", "", E.ErrInvalidProxyHeader},
+		{"short", []byte("PRO"), "", "", io.EOF},
+		{"empty", nil, "", "", io.EOF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const rest = "payload"
+			reader := bufio.NewReader(bytes.NewReader(append(tc.input, rest...)))
+			if tc.err == io.EOF || tc.err == io.ErrUnexpectedEOF {
+				// nothing follows a truncated header.
+				reader = bufio.NewReader(bytes.NewReader(tc.input))
+			}
+			src, dst, err := readProxyHeader(reader)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got error %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := addrString(src); got != tc.src {
+				t.Errorf("got source %q, want %q", got, tc.src)
+			}
+			if got := addrString(dst); got != tc.dst {
+				t.Errorf("got destination %q, want %q", got, tc.dst)
+			}
+			// what follows the header is left to be read as data of the connection.
+			data, _ := io.ReadAll(reader)
+			if string(data) != rest {
+				t.Errorf("got %q after the header, want %q", data, rest)
+			}
+		})
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func TestBuildProxyHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  net.Addr
+		dst  net.Addr
+		want string
+	}{
+		{"tcp4", &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51234}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443},
+			"203.0.113.7:51234 192.0.2.1:443"},
+		{"tcp6", &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			"[2001:db8::7]:51234 [2001:db8::1]:443"},
+		{"mixed", &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443},
+			"203.0.113.7:51234 [2001:db8::1]:443"},
+		{"unix", &net.UnixAddr{Name: "@", Net: "unix"}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}, " "},
+	} {
+		for _, version := range []string{proxyProtocolSendV1, proxyProtocolSendV2} {
+			t.Run(tc.name+" "+version, func(t *testing.T) {
+				reader := bufio.NewReader(bytes.NewReader(buildProxyHeader(version, tc.src, tc.dst)))
+				src, dst, err := readProxyHeader(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := addrString(src) + " " + addrString(dst); got != tc.want {
+					t.Errorf("got %q, want %q", got, tc.want)
+				}
+				if reader.Buffered() != 0 {
+					t.Errorf("%d bytes left after the header", reader.Buffered())
+				}
+			})
+		}
+	}
+}
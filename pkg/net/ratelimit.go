@@ -0,0 +1,92 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+// rateLimitChunk bounds how much is read or written at once, so that transfers on a shared bucket are interleaved
+// instead of one of them taking a large reservation.
+const rateLimitChunk = 16 * 1024
+
+// RateLimitedConn throttles a connection with token buckets counting bytes. reading from it counts toward the upload
+// bucket, as it's what the client sends, and writing to it counts toward the download bucket. buckets may be shared
+// between connections and can be set after the connection is created, e.g. once its user is known.
+type RateLimitedConn struct {
+	net.Conn
+	upload    atomic.Pointer[utils.TokenBucket]
+	download  atomic.Pointer[utils.TokenBucket]
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRateLimitedConn(conn net.Conn, upload, download *utils.TokenBucket) *RateLimitedConn {
+	c := &RateLimitedConn{Conn: conn, closed: make(chan struct{})}
+	c.SetLimits(upload, download)
+	return c
+}
+
+// SetLimits replaces the buckets. nil leaves that direction unlimited.
+func (c *RateLimitedConn) SetLimits(upload, download *utils.TokenBucket) {
+	c.upload.Store(upload)
+	c.download.Store(download)
+}
+
+func (c *RateLimitedConn) Read(p []byte) (int, error) {
+	bucket := c.upload.Load()
+	if bucket == nil {
+		return c.Conn.Read(p)
+	}
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 && !bucket.Wait(n, c.closed) {
+		return n, net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *RateLimitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		bucket := c.download.Load()
+		if bucket != nil {
+			if len(chunk) > rateLimitChunk {
+				chunk = chunk[:rateLimitChunk]
+			}
+			if !bucket.Wait(len(chunk), c.closed) {
+				return written, net.ErrClosed
+			}
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close aborts waiting for tokens as well.
+func (c *RateLimitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+// CloseWrite passes half-closing through to the wrapped connection, if it supports it.
+func (c *RateLimitedConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+func (c *RateLimitedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &RateLimitedConn{}
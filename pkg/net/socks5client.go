@@ -0,0 +1,138 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamDialSocks5         = "dial.socks5"
+	ParamDialSocks5Username = "dial.socks5_username"
+	ParamDialSocks5Password = "dial.socks5_password"
+
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5CommandConnect   = 0x01
+	socks5AddrIPv4         = 0x01
+	socks5AddrFQDN         = 0x03
+	socks5AddrIPv6         = 0x04
+	socks5PasswordVersion  = 0x01
+
+	socks5HandshakeTimeout = time.Duration(10) * time.Second
+)
+
+// WithSocks5Connect wraps dialer so that, if "dial.socks5" is enabled, a SOCKS5 CONNECT request for the original
+// destination of the incoming connection is sent on each dialed connection. this lets connections accepted by "redir"
+// and "tproxy" listeners reach where they were meant to, through a remote running the socks5 handler.
+func WithSocks5Connect(dialer PrimedDialerFunc, params url.Values) PrimedDialerFunc {
+	if !utils.BoolFromParameters(params, ParamDialSocks5, false) {
+		return dialer
+	}
+	username := utils.StringFromParameters(params, ParamDialSocks5Username, "")
+	password := utils.StringFromParameters(params, ParamDialSocks5Password, "")
+	return func(ctx context.Context) (net.Conn, error) {
+		destination, found := OriginalDestination(IncomingFromContext(ctx))
+		if !found {
+			return nil, errors.ErrNoOriginalDestination
+		}
+		conn, err := dialer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+		if err = socks5Connect(conn, destination, username, password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+func socks5Connect(conn net.Conn, destination net.Addr, username, password string) error {
+	methods := []byte{socks5AuthNone}
+	if username != "" {
+		methods = []byte{socks5AuthPassword}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] == socks5AuthNoAcceptable {
+		return errors.ErrSocks5Handshake
+	}
+	if reply[1] == socks5AuthPassword {
+		if len(username) > 255 || len(password) > 255 {
+			return errors.ErrInvalidSyntax
+		}
+		request := []byte{socks5PasswordVersion, byte(len(username))}
+		request = append(request, username...)
+		request = append(request, byte(len(password)))
+		request = append(request, password...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.ErrSocks5AuthFailed
+		}
+	}
+
+	request := []byte{socks5Version, socks5CommandConnect, 0}
+	addr := tcpAddrOf(destination)
+	if addr == nil {
+		return errors.ErrNoOriginalDestination
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		request = append(append(request, socks5AddrIPv4), ip4...)
+	} else {
+		request = append(append(request, socks5AddrIPv6), addr.IP.To16()...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(addr.Port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// version, reply code, reserved, and the bound address, which is skipped.
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socks5Version {
+		return errors.ErrSocks5Handshake
+	}
+	if header[1] != 0 {
+		return errors.ErrSocks5Reply(header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrFQDN:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		addrLen = int(length[0])
+	default:
+		return errors.ErrSocks5Handshake
+	}
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
@@ -0,0 +1,22 @@
+package net
+
+import (
+	"net"
+)
+
+// passthroughConn is implemented by wrappers which neither change nor look at data read from or written to the
+// connection they wrap, so that copying may bypass them and reach the socket underneath, e.g. to splice.
+type passthroughConn interface {
+	PassthroughConn() net.Conn
+}
+
+// BypassWrappers returns the connection under any passthrough wrappers of conn.
+func BypassWrappers(conn net.Conn) net.Conn {
+	for {
+		c, ok := conn.(passthroughConn)
+		if !ok {
+			return conn
+		}
+		conn = c.PassthroughConn()
+	}
+}
@@ -0,0 +1,138 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+// stdioListener treats standard input and output of the process as a single accepted connection. once that
+// connection is closed, listener closes itself too, so the process can exit. useful for SSH ProxyCommand and inetd.
+type stdioListener struct {
+	accepted  bool
+	mu        sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *stdioListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if !l.accepted {
+		l.accepted = true
+		l.mu.Unlock()
+		return &stdioConn{listener: l}, nil
+	}
+	l.mu.Unlock()
+	<-l.closed
+	return nil, &net.OpError{Op: "accept", Net: stdioAddr{}.Network(), Addr: stdioAddr{}, Err: net.ErrClosed}
+}
+
+func (l *stdioListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *stdioListener) Addr() net.Addr {
+	return stdioAddr{}
+}
+
+var _ net.Listener = &stdioListener{}
+
+type stdioConn struct {
+	listener *stdioListener
+}
+
+func (c *stdioConn) Read(b []byte) (n int, err error) {
+	return os.Stdin.Read(b)
+}
+
+func (c *stdioConn) Write(b []byte) (n int, err error) {
+	return os.Stdout.Write(b)
+}
+
+// Close closes stdin and stdout. a pending Read is not interrupted if stdin can't be polled, e.g. when it's a terminal
+// or a regular file, but the process is about to exit then anyway.
+func (c *stdioConn) Close() error {
+	_ = os.Stdout.Close()
+	err := os.Stdin.Close()
+	_ = c.listener.Close()
+	return err
+}
+
+// CloseWrite closes stdout, which the parent process reads as EOF. it's closed for the whole process, which is fine as
+// nothing else writes there: logs go to stderr, and stdio can only be listened on once.
+func (c *stdioConn) CloseWrite() error {
+	return os.Stdout.Close()
+}
+
+func (c *stdioConn) LocalAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) RemoteAddr() net.Addr {
+	return stdioAddr{}
+}
+
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets deadline of reads from stdin. deadlines aren't supported if stdin can't be polled, e.g. when
+// it's a regular file, in which case they are ignored, and so are idle timeouts.
+func (c *stdioConn) SetReadDeadline(t time.Time) error {
+	return ignoreNoDeadline(os.Stdin.SetReadDeadline(t))
+}
+
+func (c *stdioConn) SetWriteDeadline(t time.Time) error {
+	return ignoreNoDeadline(os.Stdout.SetWriteDeadline(t))
+}
+
+func ignoreNoDeadline(err error) error {
+	if errors.Is(err, os.ErrNoDeadline) {
+		return nil
+	}
+	return err
+}
+
+var _ net.Conn = &stdioConn{}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string {
+	return "stdio"
+}
+
+func (stdioAddr) String() string {
+	return "stdio"
+}
+
+var _ net.Addr = stdioAddr{}
+
+// stdioUsed is set once stdio is listened on, as there is only one of it in the process.
+var stdioUsed atomic.Bool
+
+func listenStdio(addr string, _ url.Values) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, "stdio") {
+		return nil, E.ErrUnsupportedScheme
+	}
+	if !stdioUsed.CompareAndSwap(false, true) {
+		return nil, E.ErrStdioInUse
+	}
+	return &stdioListener{closed: make(chan struct{})}, nil
+}
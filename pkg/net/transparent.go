@@ -0,0 +1,105 @@
+package net
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+// transparent proxy listeners, for connections redirected by iptables. "redir" recovers the original destination
+// using SO_ORIGINAL_DST, for the REDIRECT target, and "tproxy" listens with IP_TRANSPARENT, for the TPROXY target, where
+// the local address of connections is the original destination.
+
+type transparentListener struct {
+	net.Listener
+	tproxy bool
+}
+
+func (l *transparentListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.tproxy {
+			return &transparentConn{Conn: conn, originalDst: conn.LocalAddr()}, nil
+		}
+		tcpConn, ok := conn.(*net.TCPConn)
+		if !ok {
+			return conn, nil
+		}
+		originalDst, err := getOriginalDst(tcpConn)
+		if err != nil {
+			// connection wasn't redirected, or the kernel has no record of it. there is nowhere to forward it to.
+			_ = conn.Close()
+			continue
+		}
+		return &transparentConn{Conn: conn, originalDst: originalDst}, nil
+	}
+}
+
+var _ net.Listener = &transparentListener{}
+
+type transparentConn struct {
+	net.Conn
+	originalDst net.Addr
+}
+
+// OriginalDst returns the address the client connected to, before being redirected.
+func (c *transparentConn) OriginalDst() net.Addr {
+	return c.originalDst
+}
+
+func (c *transparentConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+func (c *transparentConn) PassthroughConn() net.Conn {
+	return c.Conn
+}
+
+func (c *transparentConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &transparentConn{}
+
+// OriginalDestination returns the address a redirected connection was meant for, looking through wrappers.
+func OriginalDestination(conn net.Conn) (net.Addr, bool) {
+	for conn != nil {
+		if c, ok := conn.(interface{ OriginalDst() net.Addr }); ok {
+			return c.OriginalDst(), true
+		}
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+	return nil, false
+}
+
+func newTransparentListener(scheme string, tproxy bool) ListenFunc {
+	return func(addr string, transportParams url.Values) (net.Listener, error) {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(u.Scheme, scheme) {
+			return nil, errors.ErrUnsupportedScheme
+		}
+		if !strings.ContainsAny(u.Host, ":") {
+			return nil, errors.ErrNoPortDefined
+		}
+		if !transparentSupported {
+			return nil, errors.ErrOpNotSupported
+		}
+		listener, err := listenTCPSocket(u.Host, transportParams, tproxy)
+		if err != nil {
+			return nil, err
+		}
+		return &transparentListener{Listener: listener, tproxy: tproxy}, nil
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package net
+
+import (
+	"encoding/binary"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from linux/netfilter_ipv4.h and linux/netfilter_ipv6/ip6_tables.h
+const (
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+const transparentSupported = true
+
+// getOriginalDst asks netfilter about the destination of a connection, before it was redirected.
+func getOriginalDst(conn *net.TCPConn) (net.Addr, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	// clients connecting over IPv4 to a dual-stack socket have IPv4-mapped addresses, and their connections are
+	// tracked as IPv4, so they are asked about at SOL_IP too.
+	ipv4 := false
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+		ipv4 = true
+	}
+	var originalDst *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			var raw unix.RawSockaddrInet4
+			sockErr = getsockopt(fd, unix.SOL_IP, soOriginalDst, unsafe.Pointer(&raw), unix.SizeofSockaddrInet4)
+			if sockErr != nil {
+				return
+			}
+			originalDst = &net.TCPAddr{IP: net.IP(append([]byte(nil), raw.Addr[:]...)), Port: int(networkPort(raw.Port))}
+			return
+		}
+		var raw unix.RawSockaddrInet6
+		sockErr = getsockopt(fd, unix.SOL_IPV6, ip6tSoOriginalDst, unsafe.Pointer(&raw), unix.SizeofSockaddrInet6)
+		if sockErr != nil {
+			return
+		}
+		originalDst = &net.TCPAddr{IP: net.IP(append([]byte(nil), raw.Addr[:]...)), Port: int(networkPort(raw.Port))}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	return originalDst, nil
+}
+
+// getsockopt reads an option of size bytes into value.
+func getsockopt(fd uintptr, level int, name int, value unsafe.Pointer, size uint32) error {
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, uintptr(level), uintptr(name), uintptr(value),
+		uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// networkPort converts a port as found in sockaddr structures, in network byte order, to host byte order.
+func networkPort(port uint16) uint16 {
+	return binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&port))[:])
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package net
+
+import (
+	"net"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+// transparent proxying is only supported on Linux.
+const transparentSupported = false
+
+func getOriginalDst(_ *net.TCPConn) (net.Addr, error) {
+	return nil, errors.ErrOpNotSupported
+}
@@ -0,0 +1,73 @@
+package net
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/udpconn"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamUDPIdleTimeout = "udp.idle_timeout"
+	ParamUDPMaxSessions = "udp.max_sessions"
+
+	defaultUDPIdleTimeout = time.Duration(60) * time.Second
+	defaultUDPMaxSessions = 1024
+)
+
+func listenUDP(addr string, transportParams url.Values) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, "udp") {
+		return nil, errors.ErrUnsupportedScheme
+	}
+	if !strings.ContainsAny(u.Host, ":") {
+		return nil, errors.ErrNoPortDefined
+	}
+	conn, err := net.ListenPacket("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return udpconn.Listen(conn,
+		listenerBacklog,
+		utils.DurationFromParameters(transportParams, ParamUDPIdleTimeout, defaultUDPIdleTimeout),
+		utils.IntegerFromParameters(transportParams, ParamUDPMaxSessions, defaultUDPMaxSessions)), nil
+}
+
+func dialUDP(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, "udp") {
+		return nil, errors.ErrUnsupportedScheme
+	}
+	dialer, err := NewNetDialer("udp", transportParams)
+	if err != nil {
+		return nil, err
+	}
+	dialer.Timeout = utils.DurationFromParameters(transportParams, "tcp.dial_timeout", defaultDialTimeout)
+	// there is no handshake to race on, so the first address which can be dialed is used, e.g. one with a route to it.
+	ips, err := ResolveHost(ctx, u.Hostname(), transportParams)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := udpconn.Dial(ctx, dialer, net.JoinHostPort(ip.String(), u.Port()))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package net
+
+func withUmask(mask int, fn func() error) (int, error) {
+	return 0, fn()
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package net
+
+import (
+	"sync"
+	"syscall"
+)
+
+var umaskMu sync.Mutex
+
+// withUmask runs fn with the file mode creation mask set to mask, and returns the mask it replaced. the mask is
+// process-wide, so files created meanwhile by other goroutines get it too, which can only make them more restrictive.
+func withUmask(mask int, fn func() error) (int, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+	return old, fn()
+}
@@ -0,0 +1,205 @@
+package net
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamUnixMode  = "unix.mode"
+	ParamUnixOwner = "unix.owner"
+	ParamUnixGroup = "unix.group"
+	ParamWSPath    = "ws.path"
+	ParamWSHost    = "ws.host"
+
+	unixSchemeSuffix = "+unix"
+	defaultWSHost    = "localhost"
+)
+
+// unixSocketPath extracts path of the socket from addresses like "unix:///run/app.sock" and "unix://@name".
+// names starting with "@" refer to abstract sockets on Linux.
+func unixSocketPath(addr string) string {
+	_, rest, _ := strings.Cut(addr, "://")
+	rest, _, _ = strings.Cut(rest, "?")
+	path, err := url.PathUnescape(rest)
+	if err != nil {
+		return rest
+	}
+	return path
+}
+
+func isAbstractUnixSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+func dialUnix(ctx context.Context, addr string, transportParams url.Values) (net.Conn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	// scheme check
+	if !strings.EqualFold(u.Scheme, "unix") {
+		return nil, errors.ErrUnsupportedScheme
+	}
+	return dialUnixTransport(ctx, unixSocketPath(addr), transportParams)
+}
+
+func dialUnixTransport(ctx context.Context, path string, transportParams url.Values) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: utils.DurationFromParameters(transportParams, "tcp.dial_timeout", defaultDialTimeout)}
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = sendProxyHeader(conn, IncomingFromContext(ctx), transportParams)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func listenUnix(addr string, transportParams url.Values) (net.Listener, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, "unix") {
+		return nil, errors.ErrUnsupportedScheme
+	}
+	return listenUnixTransport(unixSocketPath(addr), transportParams)
+}
+
+func listenUnixTransport(path string, params url.Values) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.ErrMissingPart("socket path")
+	}
+	if !isAbstractUnixSocket(path) {
+		if err := removeStaleUnixSocket(path); err != nil {
+			return nil, err
+		}
+	}
+	// the socket is created accessible by its owner only, and permissions are widened afterwards, so that nobody else
+	// can connect in between.
+	var listener net.Listener
+	umask, err := withUmask(0177, func() (err error) {
+		listener, err = net.Listen("unix", path)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !isAbstractUnixSocket(path) {
+		if err = applyUnixSocketPermissions(path, params, os.FileMode(0777&^umask)); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	wrapped, err := wrapProxyProtocolListener(listener, params)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// removeStaleUnixSocket removes socket files left behind by processes which didn't exit cleanly. sockets which are
+// still being listened on are left untouched.
+func removeStaleUnixSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.ErrNotSocket
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return errors.ErrSocketInUse
+	}
+	return os.Remove(path)
+}
+
+// applyUnixSocketPermissions sets mode, owner and group of the socket file. mode is defaultMode unless one is given.
+func applyUnixSocketPermissions(path string, params url.Values, defaultMode os.FileMode) error {
+	mode := defaultMode
+	if modeStr, found := utils.GetParameter(params, ParamUnixMode); found {
+		parsed, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			return err
+		}
+		mode = os.FileMode(parsed)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	uid, gid := -1, -1
+	if owner, found := utils.GetParameter(params, ParamUnixOwner); found {
+		id, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = id
+	}
+	if group, found := utils.GetParameter(params, ParamUnixGroup); found {
+		id, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = id
+	}
+	if uid != -1 || gid != -1 {
+		return os.Chown(path, uid, gid)
+	}
+	return nil
+}
+
+// lookupID accepts both numeric ids and names.
+func lookupID(value string, lookup func(name string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	idStr, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(idStr)
+}
+
+func isUnixWSScheme(scheme string) bool {
+	return strings.HasSuffix(strings.ToLower(scheme), unixSchemeSuffix)
+}
+
+// splitUnixWSAddr turns "ws+unix:///run/app.sock" into path of the socket and a WebSocket URL. path of the WebSocket
+// endpoint is taken from "ws.path" and Host header from "ws.host" parameters.
+func splitUnixWSAddr(addr string, u *url.URL, params url.Values) (path string, wsAddr string) {
+	wsURL := url.URL{
+		Scheme:   strings.TrimSuffix(strings.ToLower(u.Scheme), unixSchemeSuffix),
+		Host:     utils.StringFromParameters(params, ParamWSHost, defaultWSHost),
+		Path:     utils.StringFromParameters(params, ParamWSPath, "/"),
+		RawQuery: u.RawQuery,
+	}
+	return unixSocketPath(addr), wsURL.String()
+}
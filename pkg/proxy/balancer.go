@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamStrategy    = "lb.strategy"
+	ParamMaxFails    = "lb.max_fails"
+	ParamFailTimeout = "lb.fail_timeout"
+
+	defaultMaxFails    = 3
+	defaultFailTimeout = time.Duration(30) * time.Second
+)
+
+type Strategy int
+
+const (
+	StrategyRoundRobin = Strategy(iota)
+	StrategyLeastConn
+	StrategyRandom
+	StrategyFailover
+)
+
+func ParseStrategy(input string) (Strategy, error) {
+	switch strings.ToLower(input) {
+	case "round-robin", "roundrobin", "rr":
+		return StrategyRoundRobin, nil
+	case "least-conn", "least-connections", "leastconn":
+		return StrategyLeastConn, nil
+	case "random":
+		return StrategyRandom, nil
+	case "failover", "ordered":
+		return StrategyFailover, nil
+	}
+	return StrategyRoundRobin, E.ErrInvalidSyntax
+}
+
+// Upstream is one of the remote endpoints of a Balancer.
+type Upstream struct {
+	Endpoint Endpoint
+	dialer   N.PrimedDialerFunc
+	pool     *N.Pool
+	active   int64
+	probe    probeFunc
+	// guarded by balancer's mutex
+	fails     int
+	downUntil time.Time
+	unhealthy bool
+	rises     int
+	falls     int
+	lastCheck time.Time
+	lastError string
+}
+
+// UpstreamStatus is a snapshot of the state of an upstream.
+type UpstreamStatus struct {
+	Addr        string    `json:"addr"`
+	Healthy     bool      `json:"healthy"`
+	ActiveConns int64     `json:"active_conns"`
+	Fails       int       `json:"fails"`
+	LastCheck   time.Time `json:"last_check,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ActiveConns returns the number of open connections dialed through this upstream.
+func (u *Upstream) ActiveConns() int64 {
+	return atomic.LoadInt64(&u.active)
+}
+
+// Balancer distributes dials across multiple remote endpoints. a remote is skipped for a while after a number of
+// consecutive dial failures, and as long as it fails health checks.
+type Balancer struct {
+	upstreams   []*Upstream
+	strategy    Strategy
+	maxFails    int
+	failTimeout time.Duration
+	healthCheck *healthCheck
+	next        uint64
+	mu          sync.Mutex
+	rand        *rand.Rand
+	logger      logging.Logger
+}
+
+func NewBalancer(endpoints []Endpoint, params url.Values, logger logging.Logger) (*Balancer, error) {
+	strategy := StrategyRoundRobin
+	if strategyStr, found := utils.GetParameter(params, ParamStrategy); found {
+		var err error
+		strategy, err = ParseStrategy(strategyStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b := &Balancer{
+		strategy:    strategy,
+		maxFails:    utils.IntegerFromParameters(params, ParamMaxFails, defaultMaxFails),
+		failTimeout: utils.DurationFromParameters(params, ParamFailTimeout, defaultFailTimeout),
+		healthCheck: parseHealthCheck(params),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger:      logger,
+	}
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if hasHandlerCreator(u.Scheme) {
+			return nil, E.ErrNotBalanceable
+		}
+		dialer, err := N.CreateDialer(endpoint.Addr, endpoint.TransportParams)
+		if err != nil {
+			return nil, err
+		}
+		_, endpointParams, err := N.SplitParams(endpoint.Addr, endpoint.TransportParams)
+		if err != nil {
+			return nil, err
+		}
+		upstream := &Upstream{Endpoint: endpoint, dialer: dialer}
+		if upstream.pool = N.NewPoolFromParameters(dialer, endpointParams); upstream.pool != nil {
+			upstream.dialer = upstream.pool.Dial
+		}
+		if b.healthCheck != nil {
+			upstream.probe, err = createProbe(endpoint, params)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b.upstreams = append(b.upstreams, upstream)
+	}
+	return b, nil
+}
+
+// Start keeps connection pools filled and runs health checks, if configured, until done is closed.
+func (b *Balancer) Start(done <-chan struct{}) {
+	for _, upstream := range b.upstreams {
+		if upstream.pool != nil {
+			go upstream.pool.Run(done)
+		}
+	}
+	b.startHealthChecks(done)
+}
+
+// Upstreams returns the remote endpoints, in the order they were given.
+func (b *Balancer) Upstreams() []*Upstream {
+	return b.upstreams
+}
+
+// Status returns state of the upstreams, in the order they were given.
+func (b *Balancer) Status() []UpstreamStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := make([]UpstreamStatus, len(b.upstreams))
+	for i, upstream := range b.upstreams {
+		status[i] = UpstreamStatus{
+			Addr:        upstream.Endpoint.Addr,
+			Healthy:     !upstream.unhealthy,
+			ActiveConns: upstream.ActiveConns(),
+			Fails:       upstream.fails,
+			LastCheck:   upstream.lastCheck,
+			LastError:   upstream.lastError,
+		}
+	}
+	return status
+}
+
+func (b *Balancer) String() string {
+	addrs := make([]string, len(b.upstreams))
+	for i, upstream := range b.upstreams {
+		addrs[i] = upstream.Endpoint.Addr
+	}
+	return strings.Join(addrs, ", ")
+}
+
+// candidates returns upstreams in the order they should be tried. those which are down are put at the end, so they
+// are only tried if nothing else is left. unhealthy upstreams are never tried.
+func (b *Balancer) candidates() []*Upstream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ordered := make([]*Upstream, len(b.upstreams))
+	switch b.strategy {
+	case StrategyRoundRobin, StrategyLeastConn:
+		start := int(b.next % uint64(len(b.upstreams)))
+		b.next++
+		for i := range b.upstreams {
+			ordered[i] = b.upstreams[(start+i)%len(b.upstreams)]
+		}
+		if b.strategy == StrategyLeastConn {
+			// rotation above breaks ties between equally loaded upstreams.
+			sort.SliceStable(ordered, func(i, j int) bool {
+				return ordered[i].ActiveConns() < ordered[j].ActiveConns()
+			})
+		}
+	case StrategyRandom:
+		for i, j := range b.rand.Perm(len(b.upstreams)) {
+			ordered[i] = b.upstreams[j]
+		}
+	case StrategyFailover:
+		copy(ordered, b.upstreams)
+	}
+
+	healthy := ordered[:0]
+	for _, upstream := range ordered {
+		if !upstream.unhealthy {
+			healthy = append(healthy, upstream)
+		}
+	}
+	now := time.Now()
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return !healthy[i].isDown(now) && healthy[j].isDown(now)
+	})
+	return healthy
+}
+
+func (u *Upstream) isDown(now time.Time) bool {
+	return now.Before(u.downUntil)
+}
+
+func (b *Balancer) reportSuccess(upstream *Upstream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if upstream.fails >= b.maxFails {
+		b.logger.Log(logging.InfoLevel, "Remote", upstream.Endpoint.Addr, "is back up")
+	}
+	upstream.fails = 0
+	upstream.downUntil = time.Time{}
+}
+
+func (b *Balancer) reportFailure(upstream *Upstream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upstream.fails++
+	if b.maxFails > 0 && upstream.fails >= b.maxFails {
+		if upstream.fails == b.maxFails {
+			b.logger.Log(logging.WarnLevel, "Remote", upstream.Endpoint.Addr, "failed", upstream.fails,
+				"consecutive dials, skipping it for", b.failTimeout)
+		}
+		upstream.downUntil = time.Now().Add(b.failTimeout)
+	}
+}
+
+// Dial tries upstreams in order chosen by the strategy, until one of them succeeds.
+func (b *Balancer) Dial(ctx context.Context) (net.Conn, error) {
+	lastErr := E.ErrNoHealthyRemote
+	for _, upstream := range b.candidates() {
+		conn, err := upstream.dialer(ctx)
+		if err != nil {
+			b.logger.Log(logging.DebugLevel, "Failed to dial", upstream.Endpoint.Addr, err)
+			b.reportFailure(upstream)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		b.reportSuccess(upstream)
+		atomic.AddInt64(&upstream.active, 1)
+		return &upstreamConn{Conn: conn, upstream: upstream}, nil
+	}
+	return nil, lastErr
+}
+
+// upstreamConn keeps count of active connections of an upstream.
+type upstreamConn struct {
+	net.Conn
+	upstream *Upstream
+	once     sync.Once
+}
+
+func (c *upstreamConn) Close() error {
+	c.once.Do(func() {
+		atomic.AddInt64(&c.upstream.active, -1)
+	})
+	return c.Conn.Close()
+}
+
+func (c *upstreamConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+func (c *upstreamConn) PassthroughConn() net.Conn {
+	return c.Conn
+}
+
+func (c *upstreamConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &upstreamConn{}
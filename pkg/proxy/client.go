@@ -20,11 +20,14 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hadi77ir/go-logging"
 	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
 )
 
 type Endpoint struct {
@@ -33,28 +36,70 @@ type Endpoint struct {
 }
 
 type Proxy struct {
-	localEndpoint  Endpoint
-	remoteEndpoint Endpoint
-	remoteDialer   N.PrimedDialerFunc
-	wg             sync.WaitGroup
-	errChan        chan error
-	signal         chan os.Signal
-	done           chan struct{}
-	logger         logging.Logger
-	connHandler    ConnHandlerFunc
-}
-
-func NewProxy(localEndpoint, remoteEndpoint Endpoint, logger logging.Logger, sigChan chan os.Signal) *Proxy {
+	localEndpoint   Endpoint
+	remoteEndpoints []Endpoint
+	wg              sync.WaitGroup
+	errChan         chan error
+	signal          chan os.Signal
+	done            chan struct{}
+	logger          logging.Logger
+	connHandler     ConnHandlerFunc
+	balancer        atomic.Pointer[Balancer]
+	limiter         *connLimiter
+	rejections      rejectionLog
+	// shared by all connections of the tunnel.
+	uploadBucket   *utils.TokenBucket
+	downloadBucket *utils.TokenBucket
+	name           string
+	accessLog      *AccessLog
+	trackConns     bool
+	// guards what's set once running, and sessions of live connections, kept if they are tracked.
+	mu            sync.Mutex
+	started       time.Time
+	ruleCounts    map[string]int
+	sessions      map[uint64]*Session
+	lastSessionID uint64
+}
+
+// TunnelStatus describes a running proxy. Rules counts rules of each kind loaded by its remotes, e.g. "ruleset".
+type TunnelStatus struct {
+	Name        string           `json:"name"`
+	Local       string           `json:"local"`
+	Started     time.Time        `json:"started"`
+	Connections int              `json:"connections"`
+	Remotes     []UpstreamStatus `json:"remotes"`
+	Rules       map[string]int   `json:"rules"`
+}
+
+func NewProxy(localEndpoint Endpoint, remoteEndpoints []Endpoint, logger logging.Logger, sigChan chan os.Signal) *Proxy {
 	return &Proxy{
-		localEndpoint:  localEndpoint,
-		remoteEndpoint: remoteEndpoint,
-		logger:         logger,
-		errChan:        make(chan error, 1),
-		signal:         sigChan,
-		done:           make(chan struct{}),
+		localEndpoint:   localEndpoint,
+		remoteEndpoints: remoteEndpoints,
+		logger:          logger,
+		errChan:         make(chan error, 1),
+		signal:          sigChan,
+		done:            make(chan struct{}),
+		name:            localEndpoint.Addr,
+		sessions:        make(map[uint64]*Session),
 	}
 }
 
+// SetName names the tunnel in access log records. it's the local address by default.
+func (c *Proxy) SetName(name string) {
+	c.name = name
+}
+
+// SetAccessLog makes a record of each connection be written to accessLog once it's closed.
+func (c *Proxy) SetAccessLog(accessLog *AccessLog) {
+	c.accessLog = accessLog
+}
+
+// TrackConnections keeps sessions of live connections, so that they can be listed and closed. it has to be called
+// before Run.
+func (c *Proxy) TrackConnections() {
+	c.trackConns = true
+}
+
 func closeConn(logger logging.Logger, closer io.Closer) {
 	err := closer.Close()
 	if err != nil {
@@ -66,13 +111,134 @@ func closeConn(logger logging.Logger, closer io.Closer) {
 
 func (c *Proxy) proxyConn(conn net.Conn) {
 	defer c.wg.Done()
-	defer closeConn(c.logger, conn)
+	var session *Session
+	if c.accessLog != nil || c.trackConns {
+		c.mu.Lock()
+		c.lastSessionID++
+		session = newSession(c.lastSessionID, c.name, conn)
+		c.mu.Unlock()
+		session.tracked = c.trackConns
+		// deferred before closing, so that the record is written once the connection is closed.
+		defer c.endSession(session, conn)
+		if c.trackConns {
+			go session.resolveUser(conn)
+		}
+		conn = &sessionConn{Conn: conn, session: session}
+	}
+	// closes the outermost wrapper, whichever it ends up being, so that wrappers get to clean up.
+	defer func() { closeConn(c.logger, conn) }()
+	if c.limiter != nil {
+		defer c.limiter.release()
+		ip, err := c.limiter.admitIP(conn)
+		if err != nil {
+			c.rejections.log(c.logger, conn.RemoteAddr(), err)
+			session.SetCloseReason("rejected: " + err.Error())
+			return
+		}
+		defer c.limiter.releaseIP(ip)
+	}
+	defer logCompressionStats(c.logger, conn)
+	if c.uploadBucket != nil || c.downloadBucket != nil {
+		conn = N.NewRateLimitedConn(conn, c.uploadBucket, c.downloadBucket)
+	}
+	if session != nil && c.trackConns {
+		session.conn = conn
+		c.mu.Lock()
+		c.sessions[session.id] = session
+		c.mu.Unlock()
+	}
 	c.connHandler(conn, c.logger, &c.wg, c.done)
 }
 
+// endSession forgets the session of a closed connection and writes its record, after copying from it has ended.
+func (c *Proxy) endSession(session *Session, conn net.Conn) {
+	c.mu.Lock()
+	delete(c.sessions, session.id)
+	c.mu.Unlock()
+	if c.accessLog == nil {
+		return
+	}
+	session.copies.Wait()
+	session.SetCloseReason("closed")
+	session.resolveUser(conn)
+	if err := c.accessLog.Log(session.Record()); err != nil {
+		c.logger.Log(logging.ErrorLevel, "Failed to write access log:", err)
+	}
+}
+
+// Connections returns records of live connections, in the order they were accepted. it's empty unless connections
+// are tracked.
+func (c *Proxy) Connections() []AccessRecord {
+	sessions := c.liveSessions()
+	records := make([]AccessRecord, len(sessions))
+	for i, session := range sessions {
+		records[i] = session.Record()
+	}
+	return records
+}
+
+// liveSessions returns sessions of live connections, in the order they were accepted.
+func (c *Proxy) liveSessions() []*Session {
+	c.mu.Lock()
+	sessions := make([]*Session, 0, len(c.sessions))
+	for _, session := range c.sessions {
+		sessions = append(sessions, session)
+	}
+	c.mu.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].id < sessions[j].id
+	})
+	return sessions
+}
+
+// CloseConnection closes the live connection with the given id. it returns false if there is none.
+func (c *Proxy) CloseConnection(id uint64) bool {
+	c.mu.Lock()
+	session, found := c.sessions[id]
+	c.mu.Unlock()
+	if found {
+		session.close("closed by admin")
+	}
+	return found
+}
+
+// CloseUserConnections closes live connections of user, and returns how many were closed.
+func (c *Proxy) CloseUserConnections(user string) int {
+	closed := 0
+	for _, session := range c.liveSessions() {
+		if session.Record().User == user {
+			session.close("closed by admin")
+			closed++
+		}
+	}
+	return closed
+}
+
+// Status describes the proxy, once it's running.
+func (c *Proxy) Status() TunnelStatus {
+	c.mu.Lock()
+	status := TunnelStatus{
+		Name:        c.name,
+		Local:       c.localEndpoint.Addr,
+		Started:     c.started,
+		Connections: len(c.sessions),
+		Rules:       c.ruleCounts,
+	}
+	c.mu.Unlock()
+	status.Remotes = c.RemotesStatus()
+	if status.Remotes == nil {
+		status.Remotes = []UpstreamStatus{}
+	}
+	return status
+}
+
 func (c *Proxy) serve(l net.Listener) {
 	defer c.wg.Done()
 	for {
+		if c.limiter != nil && !c.limiter.wait(c.done) {
+			c.errChan <- nil
+			return
+		}
 		conn, err := l.Accept()
 		if err != nil {
 			c.logger.Log(logging.DebugLevel, "Listener error:", err)
@@ -86,11 +252,27 @@ func (c *Proxy) serve(l net.Listener) {
 			return
 		}
 
+		if c.limiter != nil {
+			if err = c.limiter.admit(); err != nil {
+				c.rejections.log(c.logger, socketAddr(conn), err)
+				closeConn(c.logger, conn)
+				continue
+			}
+		}
+
 		c.wg.Add(1)
 		go c.proxyConn(conn)
 	}
 }
 
+// RemotesStatus returns state of the remote endpoints. it's empty if they are not balanced.
+func (c *Proxy) RemotesStatus() []UpstreamStatus {
+	if balancer := c.balancer.Load(); balancer != nil {
+		return balancer.Status()
+	}
+	return nil
+}
+
 func (c *Proxy) Shutdown() {
 	select {
 	case <-c.done:
@@ -102,16 +284,39 @@ func (c *Proxy) Shutdown() {
 
 func (c *Proxy) Run() error {
 	var err error
-	c.connHandler, err = CreateHandler(c.remoteEndpoint.Addr, c.remoteEndpoint.TransportParams)
+	var balancer *Balancer
+	var ruleCounts map[string]int
+	c.connHandler, balancer, ruleCounts, err = CreateRemotesHandler(c.remoteEndpoints, c.logger)
+	if err != nil {
+		return err
+	}
+	if balancer != nil {
+		balancer.Start(c.done)
+		c.balancer.Store(balancer)
+	}
+	if ruleCounts == nil {
+		ruleCounts = make(map[string]int)
+	}
+
+	_, localParams, err := N.SplitParams(c.localEndpoint.Addr, c.localEndpoint.TransportParams)
+	if err != nil {
+		return err
+	}
+	c.limiter, err = newConnLimiter(localParams)
 	if err != nil {
 		return err
 	}
+	c.uploadBucket, c.downloadBucket = NewBandwidthBuckets(localParams, ParamUploadRate, ParamDownloadRate)
 
 	ln, err := N.ListenURL(c.localEndpoint.Addr, c.localEndpoint.TransportParams)
 	if err != nil {
 		return err
 	}
 
+	c.mu.Lock()
+	c.started = time.Now()
+	c.ruleCounts = ruleCounts
+	c.mu.Unlock()
 	c.logger.Log(logging.InfoLevel, "Proxy listener runs on", c.localEndpoint.Addr)
 	c.wg.Add(1)
 	go c.serve(ln)
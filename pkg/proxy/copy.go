@@ -1,43 +1,265 @@
 package proxy
 
 import (
+	"errors"
 	"github.com/hadi77ir/go-logging"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
 	"io"
 	"net"
+	"net/url"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func ConnCopy(dst, src net.Conn, logger logging.Logger, wg *sync.WaitGroup, copyDone chan struct{}) {
+const (
+	ParamConnIdleTimeout = "conn.idle_timeout"
+	ParamConnMaxLifetime = "conn.max_lifetime"
+)
+
+// ConnTimeouts bound how long a pair of connections are copied to each other. Idle is how long there may be no
+// traffic in either direction, and MaxLifetime is how long copying may go on at all. zero is unlimited.
+type ConnTimeouts struct {
+	Idle        time.Duration
+	MaxLifetime time.Duration
+}
+
+func ParseConnTimeouts(params url.Values) ConnTimeouts {
+	return ConnTimeouts{
+		Idle:        utils.DurationFromParameters(params, ParamConnIdleTimeout, 0),
+		MaxLifetime: utils.DurationFromParameters(params, ParamConnMaxLifetime, 0),
+	}
+}
+
+// CopyDeadlines enforces ConnTimeouts with deadlines on both connections. read deadlines are pushed forward whenever
+// either connection is read from, as a timed out read can't be retried on some connections, like WebSocket ones.
+type CopyDeadlines struct {
+	timeouts ConnTimeouts
+	conns    [2]net.Conn
+	expires  time.Time
+	// unix time in nanoseconds when read deadlines were last pushed forward.
+	extended   atomic.Int64
+	reasonOnce sync.Once
+}
+
+// NewCopyDeadlines sets initial deadlines on conn and rConn. it returns nil if there are no timeouts.
+func NewCopyDeadlines(timeouts ConnTimeouts, conn, rConn net.Conn) *CopyDeadlines {
+	if timeouts.Idle <= 0 && timeouts.MaxLifetime <= 0 {
+		return nil
+	}
+	d := &CopyDeadlines{timeouts: timeouts, conns: [2]net.Conn{conn, rConn}}
+	now := time.Now()
+	if timeouts.MaxLifetime > 0 {
+		d.expires = now.Add(timeouts.MaxLifetime)
+		for _, c := range d.conns {
+			// writes which block past the lifetime are given up as well.
+			_ = c.SetWriteDeadline(d.expires)
+		}
+	}
+	d.extend(now)
+	return d
+}
+
+func (d *CopyDeadlines) extend(now time.Time) {
+	d.extended.Store(now.UnixNano())
+	deadline := d.expires
+	if d.timeouts.Idle > 0 {
+		if idle := now.Add(d.timeouts.Idle); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	for _, c := range d.conns {
+		_ = c.SetReadDeadline(deadline)
+	}
+}
+
+// activity is called after each successful read. deadlines are pushed forward at most every tenth of the idle timeout,
+// or every second, whichever is shorter.
+func (d *CopyDeadlines) activity() {
+	if d.timeouts.Idle <= 0 {
+		return
+	}
+	granularity := d.timeouts.Idle / 10
+	if granularity > time.Second {
+		granularity = time.Second
+	}
+	now := time.Now()
+	if now.Sub(time.Unix(0, d.extended.Load())) >= granularity {
+		d.extend(now)
+	}
+}
+
+// reason tells why copying stopped with err, if it was because of a timeout. only the first call returns a reason,
+// so that it's logged once for both directions.
+func (d *CopyDeadlines) reason(err error) (reason string, timedOut bool) {
+	// WebSocket connections hide the original error, though it's still a timeout.
+	var netErr net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return "", false
+	}
+	d.reasonOnce.Do(func() {
+		if !d.expires.IsZero() && !time.Now().Before(d.expires) {
+			reason = "reached max lifetime of " + d.timeouts.MaxLifetime.String()
+		} else {
+			reason = "idle for " + d.timeouts.Idle.String()
+		}
+	})
+	return reason, true
+}
+
+// activityReader notifies deadlines of successful reads.
+type activityReader struct {
+	net.Conn
+	deadlines *CopyDeadlines
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		r.deadlines.activity()
+	}
+	return n, err
+}
+
+// copyConn splices TCP connections, to each other or to Unix ones, on Linux, when there are only passthrough wrappers
+// on them. other connections are copied through a pooled buffer. bytes written to dst are added to written, if it's not
+// nil, which happens at once when splicing.
+func copyConn(dst, src net.Conn, written *atomic.Int64) error {
+	rawDst, rawSrc := N.BypassWrappers(dst), N.BypassWrappers(src)
+	var n int64
+	var err error
+	switch d := rawDst.(type) {
+	case *net.TCPConn:
+		switch rawSrc.(type) {
+		case *net.TCPConn, *net.UnixConn:
+			n, err = d.ReadFrom(rawSrc)
+			addWritten(written, n)
+			return err
+		}
+	case *net.UnixConn:
+		if s, ok := rawSrc.(*net.TCPConn); ok {
+			n, err = s.WriteTo(d)
+			addWritten(written, n)
+			return err
+		}
+	}
+	return copyBuffered(dst, src, written)
+}
+
+func addWritten(written *atomic.Int64, n int64) {
+	if written != nil {
+		written.Add(n)
+	}
+}
+
+// copyBuffered hides ReadFrom and WriteTo methods of dst and src, which would make io.CopyBuffer allocate a buffer of
+// its own instead.
+func copyBuffered(dst io.Writer, src io.Reader, written *atomic.Int64) error {
+	buf := utils.GetCopyBuffer()
+	defer utils.PutCopyBuffer(buf)
+	if written != nil {
+		dst = &countingWriter{Writer: dst, n: written}
+	}
+	_, err := io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+	return err
+}
+
+// ConnCopy copies src to dst until either fails, or src reaches EOF, in which case dst is half-closed. it returns
+// whether that succeeded, so that the opposite direction may go on, and why copying stopped, which is "closed" if src
+// was closed by its peer, and empty if it's only a consequence of the other direction ending. deadlines and written
+// may be nil.
+func ConnCopy(dst, src net.Conn, logger logging.Logger, deadlines *CopyDeadlines, written *atomic.Int64) (halfClosed bool, reason string) {
+	var err error
+	if deadlines == nil || deadlines.timeouts.Idle <= 0 {
+		err = copyConn(dst, src, written)
+	} else {
+		err = copyBuffered(dst, &activityReader{Conn: src, deadlines: deadlines}, written)
+	}
+	if err == nil {
+		return N.CloseWrite(dst) == nil, "closed"
+	}
+	if deadlines != nil {
+		if reason, timedOut := deadlines.reason(err); timedOut {
+			if reason != "" {
+				logger.Log(logging.InfoLevel, "Closing connection from", src.RemoteAddr(), "to", dst.RemoteAddr(), ":", reason)
+			}
+			return false, reason
+		}
+	}
+	opErr, ok := err.(*net.OpError)
+	switch {
+	case ok && opErr.Op == "readfrom":
+		return false, "closed"
+	case ok && opErr.Op == "read":
+		return false, "closed"
+	case errors.Is(err, net.ErrClosed):
+		// the other direction has ended, and connections were closed.
+		return false, ""
+	default:
+	}
+	logger.Log(logging.ErrorLevel, "Failed to copy connection from",
+		src.RemoteAddr(), "to", dst.RemoteAddr(), ":", err)
+	return false, "error: " + err.Error()
+}
+
+// DuplexCopy copies conn and rConn to each other, and closes ch when done. that's when both directions are
+// half-closed, or either of them fails or can't be half-closed. conn is the client, whose session, if any, gets bytes
+// copied and the reason copying stopped.
+func DuplexCopy(conn, rConn net.Conn, logger logging.Logger, wg *sync.WaitGroup, ch chan struct{}, timeouts ConnTimeouts) {
 	defer wg.Done()
-	defer func() {
-		select {
-		case <-copyDone:
-			return
-		default:
-			close(copyDone)
+	defer close(ch)
+
+	session := SessionFromConn(conn)
+	in, out := session.counters()
+	if session.isTracked() {
+		// spliced bytes are only counted once splicing ends, which would list live connections with none.
+		conn = &unsplicedConn{Conn: conn}
+	}
+	deadlines := NewCopyDeadlines(timeouts, conn, rConn)
+	halfClosed := make(chan bool, 2)
+	wg.Add(2)
+	// the caller has added one for this call already, so that the session isn't reported before counting is done.
+	session.addCopies(2)
+	defer session.doneCopy()
+	go func() {
+		defer wg.Done()
+		defer session.doneCopy()
+		ok, reason := ConnCopy(rConn, conn, logger, deadlines, in)
+		if reason == "closed" {
+			reason = "client closed"
 		}
+		session.SetCloseReason(reason)
+		halfClosed <- ok
 	}()
-	_, err := io.Copy(dst, src)
-	if err != nil {
-		opErr, ok := err.(*net.OpError)
-		switch {
-		case ok && opErr.Op == "readfrom":
-			return
-		case ok && opErr.Op == "read":
+	go func() {
+		defer wg.Done()
+		defer session.doneCopy()
+		ok, reason := ConnCopy(conn, rConn, logger, deadlines, out)
+		if reason == "closed" {
+			reason = "remote closed"
+		}
+		session.SetCloseReason(reason)
+		halfClosed <- ok
+	}()
+	// rConn and conn will be closed by defer calls in handlers and proxyConn, which ends the other direction.
+	for i := 0; i < 2; i++ {
+		if !<-halfClosed {
 			return
-		default:
 		}
-		logger.Log(logging.ErrorLevel, "Failed to copy connection from",
-			src.RemoteAddr(), "to", dst.RemoteAddr(), ":", err)
 	}
 }
 
-func DuplexCopy(conn, rConn net.Conn, logger logging.Logger, wg *sync.WaitGroup, ch chan struct{}) {
-	defer wg.Done()
+// unsplicedConn keeps copying from bypassing the connection under it, so that it goes through a buffer.
+type unsplicedConn struct {
+	net.Conn
+}
 
-	wg.Add(2)
-	go ConnCopy(rConn, conn, logger, wg, ch)
-	go ConnCopy(conn, rConn, logger, wg, ch)
-	// rConn and conn will be closed by defer calls in handlers and proxyConn. There is nothing to do here.
-	<-ch
+func (c *unsplicedConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+func (c *unsplicedConn) NetConn() net.Conn {
+	return c.Conn
 }
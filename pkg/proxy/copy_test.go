@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hadi77ir/go-logging/logrus"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const benchmarkPayloadSize = 4 * 1024 * 1024
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(b *testing.B) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		b.Fatal("accept failed")
+	}
+	return dialed, conn
+}
+
+// opaqueConn hides the connection under it from copying, which has to go through a buffer.
+type opaqueConn struct {
+	net.Conn
+}
+
+func (c *opaqueConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+// BenchmarkConnCopy copies between TCP connections, which are spliced if their wrappers let them, and copied through
+// a pooled buffer otherwise.
+func BenchmarkConnCopy(b *testing.B) {
+	logger, err := logrus.New("test")
+	if err != nil {
+		b.Fatal(err)
+	}
+	payload := make([]byte, benchmarkPayloadSize)
+	for _, bc := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"splice", func(conn net.Conn) net.Conn { return &sessionConn{Conn: conn} }},
+		{"buffered", func(conn net.Conn) net.Conn { return &opaqueConn{Conn: conn} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(benchmarkPayloadSize)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				srcPeer, src := tcpPair(b)
+				dst, dstPeer := tcpPair(b)
+				drained := make(chan struct{})
+				go func() {
+					_, _ = srcPeer.Write(payload)
+					_ = N.CloseWrite(srcPeer)
+				}()
+				go func() {
+					_, _ = io.Copy(io.Discard, dstPeer)
+					close(drained)
+				}()
+				b.StartTimer()
+
+				if ok, _ := ConnCopy(bc.wrap(dst), bc.wrap(src), logger, nil, nil); !ok {
+					b.Fatal("copy didn't end with EOF")
+				}
+				<-drained
+
+				b.StopTimer()
+				for _, conn := range []net.Conn{srcPeer, src, dst, dstPeer} {
+					_ = conn.Close()
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkDynamicMultiReaderWriteTo reads a stream of chunks, with a pooled buffer in WriteTo, and with a buffer
+// allocated by io.Copy for each call otherwise.
+func BenchmarkDynamicMultiReaderWriteTo(b *testing.B) {
+	chunk := make([]byte, 64*1024)
+	const chunks = benchmarkPayloadSize / (64 * 1024)
+	newReader := func() io.Reader {
+		remaining := chunks
+		return utils.NewMultiReader(func() bool { return remaining > 0 }, func() (io.Reader, error) {
+			if remaining == 0 {
+				return nil, io.EOF
+			}
+			remaining--
+			return &chunkReader{chunk: chunk}, nil
+		})
+	}
+	for _, bc := range []struct {
+		name string
+		copy func(reader io.Reader) (int64, error)
+	}{
+		{"pool", func(reader io.Reader) (int64, error) {
+			return reader.(io.WriterTo).WriteTo(struct{ io.Writer }{io.Discard})
+		}},
+		{"nopool", func(reader io.Reader) (int64, error) {
+			return io.Copy(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{reader})
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(benchmarkPayloadSize)
+			for i := 0; i < b.N; i++ {
+				n, err := bc.copy(newReader())
+				if err != nil && err != io.EOF {
+					b.Fatal(err)
+				}
+				if n != benchmarkPayloadSize {
+					b.Fatal("copied", n, "bytes")
+				}
+			}
+		})
+	}
+}
+
+// chunkReader reads chunk once, without allocating.
+type chunkReader struct {
+	chunk []byte
+	off   int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.off >= len(r.chunk) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunk[r.off:])
+	r.off += n
+	return n, nil
+}
@@ -1,9 +1,12 @@
 package proxy
 
 import (
+	"context"
 	"github.com/hadi77ir/go-logging"
 	"github.com/hadi77ir/go-registry"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
 	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
 	"net"
 	"net/url"
 	"sync"
@@ -14,42 +17,118 @@ type ConnHandlerCreatorFunc func(addr string, transportParams url.Values) (ConnH
 
 var HandlerCreators = &registry.Registry[ConnHandlerCreatorFunc]{}
 
+// RuleCountingHandlerCreatorFunc creates a handler which loads rules, and tells how many of each kind, e.g. "ruleset" or
+// "rewrites", it has loaded.
+type RuleCountingHandlerCreatorFunc func(addr string, transportParams url.Values) (ConnHandlerFunc, map[string]int, error)
+
+// RuleCountingHandlerCreators are registered by handlers which load rules, in place of HandlerCreators.
+var RuleCountingHandlerCreators = &registry.Registry[RuleCountingHandlerCreatorFunc]{}
+
+// hasHandlerCreator tells whether connections to scheme are handled by a registered handler, rather than dialed.
+func hasHandlerCreator(scheme string) bool {
+	if _, found := HandlerCreators.Get(scheme); found {
+		return true
+	}
+	_, found := RuleCountingHandlerCreators.Get(scheme)
+	return found
+}
+
 func CreateDirectDialHandler(addr string, transportParams url.Values) (ConnHandlerFunc, error) {
 	dialer, err := N.CreateDialer(addr, transportParams)
 	if err != nil {
 		return nil, err
 	}
-	return PrimedDialerToHandler(addr, dialer)
+	_, params, err := N.SplitParams(addr, transportParams)
+	if err != nil {
+		return nil, err
+	}
+	return PrimedDialerToHandler(addr, N.WithSocks5Connect(dialer, params), ParseRetryPolicy(params), ParseConnTimeouts(params))
 }
 
-func PrimedDialerToHandler(addr string, dialer N.PrimedDialerFunc) (ConnHandlerFunc, error) {
+func PrimedDialerToHandler(addr string, dialer N.PrimedDialerFunc, retry RetryPolicy, timeouts ConnTimeouts) (ConnHandlerFunc, error) {
 	return func(incoming net.Conn, logger logging.Logger, wg *sync.WaitGroup, done <-chan struct{}) {
-		rConn, err := dialer()
+		ctx, cancel := context.WithCancel(N.ContextWithIncoming(context.Background(), incoming))
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		session := SessionFromConn(incoming)
+		rConn, err := dialWithRetries(ctx, addr, dialer, retry, logger)
 		if err != nil {
 			logger.Log(logging.ErrorLevel, "Failed to dial", addr, err)
+			session.SetCloseReason("dial failed: " + err.Error())
 			return
 		}
 		defer closeConn(logger, rConn)
+		defer logCompressionStats(logger, rConn)
+		session.SetDestination(rConn.RemoteAddr().String(), rConn.RemoteAddr().String())
 
 		// copy
 		ch := make(chan struct{})
 		wg.Add(1)
-		go DuplexCopy(incoming, rConn, logger, wg, ch)
+		session.addCopies(1)
+		go DuplexCopy(incoming, rConn, logger, wg, ch, timeouts)
 
 		select {
 		case <-done:
+			session.SetCloseReason("shutdown")
 		case <-ch:
 		}
 	}, nil
 }
 
-func CreateHandler(addr string, transportParams url.Values) (ConnHandlerFunc, error) {
+// CreateRemotesHandler creates a handler for the remote endpoints. multiple endpoints, or a single one with health
+// checks or a connection pool, go through a balancer, which is only possible if all of them can be dialed. returned
+// balancer is nil otherwise. rule counts are those of the handler, nil if it loads none.
+func CreateRemotesHandler(endpoints []Endpoint, logger logging.Logger) (ConnHandlerFunc, *Balancer, map[string]int, error) {
+	if len(endpoints) == 0 {
+		return nil, nil, nil, E.ErrMissingPart("remote endpoint")
+	}
+	if len(endpoints) == 1 {
+		_, params, err := N.SplitParams(endpoints[0].Addr, endpoints[0].TransportParams)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !healthCheckEnabled(params) && utils.IntegerFromParameters(params, N.ParamPoolSize, 0) <= 0 {
+			handler, ruleCounts, err := CreateHandler(endpoints[0].Addr, endpoints[0].TransportParams)
+			return handler, nil, ruleCounts, err
+		}
+	}
+	balancer, err := NewBalancer(endpoints, endpoints[0].TransportParams, logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// parameters set in the query of the first remote apply, as they do for a single one.
+	_, params, err := N.SplitParams(endpoints[0].Addr, endpoints[0].TransportParams)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	handler, err := PrimedDialerToHandler(balancer.String(), N.WithSocks5Connect(balancer.Dial, params),
+		ParseRetryPolicy(params), ParseConnTimeouts(params))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return handler, balancer, nil, nil
+}
+
+// CreateHandler creates the handler registered for the scheme of addr, or one dialing addr if there's none. rule counts
+// are nil unless the handler loads rules.
+func CreateHandler(addr string, transportParams url.Values) (ConnHandlerFunc, map[string]int, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if handlerCreator, found := HandlerCreators.Get(u.Scheme); found {
+	if handlerCreator, found := RuleCountingHandlerCreators.Get(u.Scheme); found {
 		return handlerCreator(addr, transportParams)
 	}
-	return CreateDirectDialHandler(addr, transportParams)
+	if handlerCreator, found := HandlerCreators.Get(u.Scheme); found {
+		handler, err := handlerCreator(addr, transportParams)
+		return handler, nil, err
+	}
+	handler, err := CreateDirectDialHandler(addr, transportParams)
+	return handler, nil, err
 }
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamHealthCheckType     = "hc.type"
+	ParamHealthCheckInterval = "hc.interval"
+	ParamHealthCheckTimeout  = "hc.timeout"
+	ParamHealthCheckRise     = "hc.rise"
+	ParamHealthCheckFall     = "hc.fall"
+	ParamHealthCheckPath     = "hc.path"
+
+	healthCheckTCP  = "tcp"
+	healthCheckTLS  = "tls"
+	healthCheckWS   = "ws"
+	healthCheckHTTP = "http"
+
+	defaultHealthCheckInterval = time.Duration(10) * time.Second
+	defaultHealthCheckTimeout  = time.Duration(5) * time.Second
+	defaultHealthCheckRise     = 2
+	defaultHealthCheckFall     = 3
+)
+
+type probeFunc func(ctx context.Context) error
+
+type healthCheck struct {
+	interval time.Duration
+	timeout  time.Duration
+	rise     int
+	fall     int
+}
+
+func healthCheckEnabled(params url.Values) bool {
+	checkType, found := utils.GetParameter(params, ParamHealthCheckType)
+	return found && checkType != ""
+}
+
+func parseHealthCheck(params url.Values) *healthCheck {
+	if !healthCheckEnabled(params) {
+		return nil
+	}
+	return &healthCheck{
+		interval: utils.DurationFromParameters(params, ParamHealthCheckInterval, defaultHealthCheckInterval),
+		timeout:  utils.DurationFromParameters(params, ParamHealthCheckTimeout, defaultHealthCheckTimeout),
+		rise:     utils.IntegerFromParameters(params, ParamHealthCheckRise, defaultHealthCheckRise),
+		fall:     utils.IntegerFromParameters(params, ParamHealthCheckFall, defaultHealthCheckFall),
+	}
+}
+
+// createProbe creates a probe for the endpoint. "tcp" only connects, "tls" completes a TLS handshake, "ws" dials the
+// endpoint itself, completing the WebSocket upgrade, and "http" sends a GET request for "hc.path" and expects a
+// successful response. PROXY protocol headers sent by probes don't carry any address.
+func createProbe(endpoint Endpoint, params url.Values) (probeFunc, error) {
+	u, err := url.Parse(endpoint.Addr)
+	if err != nil {
+		return nil, err
+	}
+	checkType := strings.ToLower(utils.StringFromParameters(params, ParamHealthCheckType, ""))
+	if checkType == healthCheckWS {
+		if !strings.HasPrefix(strings.ToLower(u.Scheme), "ws") {
+			return nil, E.ErrUnsupportedScheme
+		}
+		return dialerProbe(endpoint.Addr, endpoint.TransportParams)
+	}
+
+	transportAddr, err := probeTransportAddr(u)
+	if err != nil {
+		return nil, err
+	}
+	switch checkType {
+	case healthCheckTCP:
+		return dialerProbe(strings.Replace(transportAddr, "tls://", "tcp://", 1), endpoint.TransportParams)
+	case healthCheckTLS:
+		return dialerProbe(strings.Replace(transportAddr, "tcp://", "tls://", 1), endpoint.TransportParams)
+	case healthCheckHTTP:
+		dialer, err := N.CreateDialer(transportAddr, endpoint.TransportParams)
+		if err != nil {
+			return nil, err
+		}
+		host := u.Host
+		if strings.HasPrefix(transportAddr, "unix://") {
+			host = utils.StringFromParameters(endpoint.TransportParams, N.ParamWSHost, "localhost")
+		}
+		return httpProbe(dialer, host, utils.StringFromParameters(params, ParamHealthCheckPath, "/")), nil
+	}
+	return nil, E.ErrInvalidSyntax
+}
+
+// probeTransportAddr returns address of the transport under the endpoint, i.e. without any WebSocket layer on top.
+func probeTransportAddr(u *url.URL) (string, error) {
+	query := ""
+	if u.RawQuery != "" {
+		query = "?" + u.RawQuery
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "tcp":
+		return "tcp://" + u.Host + query, nil
+	case "ws":
+		return "tcp://" + N.AddDefaultPort(u.Host, "ws") + query, nil
+	case "tls", "wss":
+		return "tls://" + N.AddDefaultPort(u.Host, "wss") + query, nil
+	case "unix", "ws+unix", "wss+unix":
+		host := u.Host
+		if u.User != nil {
+			// names of abstract sockets, as in "unix://@name", are parsed as an empty user.
+			host = u.User.String() + "@" + host
+		}
+		return "unix://" + host + u.Path + query, nil
+	}
+	return "", E.ErrUnsupportedScheme
+}
+
+func dialerProbe(addr string, params url.Values) (probeFunc, error) {
+	dialer, err := N.CreateDialer(addr, params)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		conn, err := dialer(ctx)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, nil
+}
+
+func httpProbe(dialer N.PrimedDialerFunc, host string, path string) probeFunc {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer(ctx)
+			},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	target := (&url.URL{Scheme: "http", Host: host, Path: path}).String()
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		_ = response.Body.Close()
+		if response.StatusCode >= http.StatusBadRequest {
+			return E.ErrUnhealthyStatus(response.Status)
+		}
+		return nil
+	}
+}
+
+// startHealthChecks probes upstreams periodically until done is closed. it does nothing if health checks are not
+// configured.
+func (b *Balancer) startHealthChecks(done <-chan struct{}) {
+	if b.healthCheck == nil {
+		return
+	}
+	for _, upstream := range b.upstreams {
+		go b.runHealthCheck(upstream, done)
+	}
+}
+
+func (b *Balancer) runHealthCheck(upstream *Upstream, done <-chan struct{}) {
+	ticker := time.NewTicker(b.healthCheck.interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), b.healthCheck.timeout)
+		err := upstream.probe(ctx)
+		cancel()
+		b.reportHealth(upstream, err)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Balancer) reportHealth(upstream *Upstream, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upstream.lastCheck = time.Now()
+	if err != nil {
+		upstream.lastError = err.Error()
+		upstream.rises = 0
+		upstream.falls++
+		if !upstream.unhealthy && upstream.falls >= b.healthCheck.fall {
+			upstream.unhealthy = true
+			b.logger.Log(logging.WarnLevel, "Remote", upstream.Endpoint.Addr, "is unhealthy:", err)
+		}
+		return
+	}
+	upstream.lastError = ""
+	upstream.falls = 0
+	upstream.rises++
+	if upstream.unhealthy && upstream.rises >= b.healthCheck.rise {
+		upstream.unhealthy = false
+		b.logger.Log(logging.InfoLevel, "Remote", upstream.Endpoint.Addr, "is healthy")
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamMaxConns      = "limit.max_conns"
+	ParamMaxConnsPerIP = "limit.max_conns_per_ip"
+	ParamAcceptRate    = "limit.accept_rate"
+	ParamAcceptBurst   = "limit.accept_burst"
+	ParamLimitMode     = "limit.mode"
+	ParamUploadRate    = "limit.upload_rate"
+	ParamDownloadRate  = "limit.download_rate"
+
+	limitModeReject = "reject"
+	limitModeQueue  = "queue"
+
+	rejectionLogInterval = time.Duration(10) * time.Second
+)
+
+// connLimiter caps the number of connections being served. in queue mode, excess connections are left in the
+// listener's backlog until there is room for them. otherwise they are closed right after being accepted. limits per IP
+// always reject, as the address of a connection is only known after accepting it.
+type connLimiter struct {
+	queue      bool
+	maxPerIP   int
+	slots      chan struct{}
+	acceptRate *utils.TokenBucket
+	mu         sync.Mutex
+	perIP      map[string]int
+}
+
+// newConnLimiter returns nil if no limits are set.
+func newConnLimiter(params url.Values) (*connLimiter, error) {
+	l := &connLimiter{
+		maxPerIP: utils.IntegerFromParameters(params, ParamMaxConnsPerIP, 0),
+		perIP:    make(map[string]int),
+	}
+	switch mode := strings.ToLower(utils.StringFromParameters(params, ParamLimitMode, limitModeReject)); mode {
+	case limitModeReject:
+	case limitModeQueue:
+		l.queue = true
+	default:
+		return nil, E.ErrInvalidSyntax
+	}
+	if maxConns := utils.IntegerFromParameters(params, ParamMaxConns, 0); maxConns > 0 {
+		l.slots = make(chan struct{}, maxConns)
+	}
+	if rate := utils.FloatFromParameters(params, ParamAcceptRate, 0); rate > 0 {
+		burst := utils.IntegerFromParameters(params, ParamAcceptBurst, int(math.Ceil(rate)))
+		l.acceptRate = utils.NewTokenBucket(rate, burst)
+	}
+	if l.slots == nil && l.acceptRate == nil && l.maxPerIP <= 0 {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// wait blocks before accepting the next connection, until it may be served. it returns false if done is closed
+// first. it does nothing in reject mode.
+func (l *connLimiter) wait(done <-chan struct{}) bool {
+	if !l.queue {
+		return true
+	}
+	if l.acceptRate != nil && !l.acceptRate.Wait(1, done) {
+		return false
+	}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+// admit checks limits on a connection which was just accepted. it's called from the accept loop, so it doesn't look
+// at the address of the connection, which may block.
+func (l *connLimiter) admit() error {
+	if l.queue {
+		// already waited.
+		return nil
+	}
+	// slot is taken first, so that connections rejected for lack of one don't use up the accept rate.
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			return E.ErrTooManyConnections
+		}
+	}
+	if l.acceptRate != nil && !l.acceptRate.Allow() {
+		l.release()
+		return E.ErrAcceptRateExceeded
+	}
+	return nil
+}
+
+// release frees the slot taken by wait or admit.
+func (l *connLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// admitIP checks the per-IP limit. releaseIP has to be called if it succeeds.
+func (l *connLimiter) admitIP(conn net.Conn) (string, error) {
+	if l.maxPerIP <= 0 {
+		return "", nil
+	}
+	ip := remoteIP(conn)
+	if ip == "" {
+		return "", nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return "", E.ErrTooManyConnectionsFromIP
+	}
+	l.perIP[ip]++
+	return ip, nil
+}
+
+func (l *connLimiter) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// rejectionLog warns about a rejected connection once in each interval, along with how many more were rejected since
+// the last warning, so that a flood of connections doesn't flood the log too. the others are logged at debug level.
+type rejectionLog struct {
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// socketAddr returns the remote address of the socket under conn, which is known without blocking, unlike that of
+// some wrappers, e.g. those reading a PROXY protocol header.
+func socketAddr(conn net.Conn) net.Addr {
+	for {
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok || unwrapper.NetConn() == nil {
+			return conn.RemoteAddr()
+		}
+		conn = unwrapper.NetConn()
+	}
+}
+
+func (r *rejectionLog) log(logger logging.Logger, addr net.Addr, err error) {
+	r.mu.Lock()
+	now := time.Now()
+	if now.Sub(r.last) < rejectionLogInterval {
+		r.suppressed++
+		r.mu.Unlock()
+		logger.Log(logging.DebugLevel, "Rejected connection from", addr, err)
+		return
+	}
+	suppressed := r.suppressed
+	r.last, r.suppressed = now, 0
+	r.mu.Unlock()
+	if suppressed > 0 {
+		logger.Log(logging.WarnLevel, "Rejected connection from", addr, err, "and", suppressed, "more since last warning")
+		return
+	}
+	logger.Log(logging.WarnLevel, "Rejected connection from", addr, err)
+}
+
+// NewBandwidthBuckets creates token buckets for rates, in bytes per second, of uploadKey and downloadKey in params.
+// buckets allow bursts of up to a second's worth of bytes. they are nil for rates which aren't set.
+func NewBandwidthBuckets(params url.Values, uploadKey, downloadKey string) (upload, download *utils.TokenBucket) {
+	if rate := utils.SizeFromParameters(params, uploadKey, 0); rate > 0 {
+		upload = utils.NewTokenBucket(float64(rate), int(rate))
+	}
+	if rate := utils.SizeFromParameters(params, downloadKey, 0); rate > 0 {
+		download = utils.NewTokenBucket(float64(rate), int(rate))
+	}
+	return upload, download
+}
+
+// remoteIP returns IP address of the client, or an empty string for connections which don't have one, like those on
+// unix sockets.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamDialRetries    = "dial.retries"
+	ParamDialBackoff    = "dial.backoff"
+	ParamDialMaxBackoff = "dial.max_backoff"
+	ParamDialDeadline   = "dial.deadline"
+
+	defaultDialBackoff    = time.Duration(100) * time.Millisecond
+	defaultDialMaxBackoff = time.Duration(5) * time.Second
+	defaultDialDeadline   = time.Duration(30) * time.Second
+)
+
+// RetryPolicy describes how failed dials are retried, while the incoming connection is held open.
+type RetryPolicy struct {
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Deadline bounds all the attempts together.
+	Deadline time.Duration
+}
+
+func ParseRetryPolicy(params url.Values) RetryPolicy {
+	return RetryPolicy{
+		Retries:    utils.IntegerFromParameters(params, ParamDialRetries, 0),
+		Backoff:    utils.DurationFromParameters(params, ParamDialBackoff, defaultDialBackoff),
+		MaxBackoff: utils.DurationFromParameters(params, ParamDialMaxBackoff, defaultDialMaxBackoff),
+		Deadline:   utils.DurationFromParameters(params, ParamDialDeadline, defaultDialDeadline),
+	}
+}
+
+// delay returns time to wait before the given retry, starting from 1. it's chosen randomly from the upper half of the
+// exponential backoff, so that clients dropped together don't retry together.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	backoff := p.Backoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// dialWithRetries calls dialer until it succeeds, retries are exhausted or ctx is done.
+func dialWithRetries(ctx context.Context, addr string, dialer N.PrimedDialerFunc, policy RetryPolicy,
+	logger logging.Logger) (net.Conn, error) {
+	if policy.Retries <= 0 {
+		return dialer(ctx)
+	}
+	if policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Deadline)
+		defer cancel()
+	}
+	for retry := 1; ; retry++ {
+		conn, err := dialer(ctx)
+		if err == nil || retry > policy.Retries || ctx.Err() != nil {
+			return conn, err
+		}
+		delay := policy.delay(retry)
+		logger.Log(logging.DebugLevel, "Failed to dial", addr, err, "retrying in", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+// AccessRecord describes a proxied connection. bytes in are those received from the client, and bytes out are those
+// sent to it.
+type AccessRecord struct {
+	ID                   uint64    `json:"id"`
+	Start                time.Time `json:"start"`
+	Tunnel               string    `json:"tunnel"`
+	Client               string    `json:"client"`
+	User                 string    `json:"user,omitempty"`
+	Destination          string    `json:"destination,omitempty"`
+	RewrittenDestination string    `json:"rewritten_destination,omitempty"`
+	Rule                 string    `json:"rule,omitempty"`
+	BytesIn              int64     `json:"bytes_in"`
+	BytesOut             int64     `json:"bytes_out"`
+	DurationMs           int64     `json:"duration_ms"`
+	CloseReason          string    `json:"close_reason,omitempty"`
+}
+
+// Session gathers what's known about a connection while it's being proxied. handlers find it with SessionFromConn
+// and fill in what they learn, e.g. the requested destination. its methods do nothing on a nil session, which is
+// what connections without one get.
+type Session struct {
+	id     uint64
+	tunnel string
+	client string
+	start  time.Time
+	// what the handler is given, closed to close the connection from outside.
+	conn net.Conn
+	// listed among live connections, which need their bytes counted as they are copied.
+	tracked  bool
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// copy goroutines which may still be counting after the handler has returned.
+	copies sync.WaitGroup
+
+	mu                   sync.Mutex
+	user                 string
+	destination          string
+	rewrittenDestination string
+	rule                 string
+	closeReason          string
+}
+
+func newSession(id uint64, tunnel string, conn net.Conn) *Session {
+	return &Session{id: id, tunnel: tunnel, client: conn.RemoteAddr().String(), start: time.Now()}
+}
+
+func (s *Session) SetUser(user string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// resolveUser records the user authenticated by the transport of conn, e.g. with a TLS client certificate, unless one
+// is recorded already. it waits for the TLS handshake to be done.
+func (s *Session) resolveUser(conn net.Conn) {
+	user := N.AuthenticatedUser(conn)
+	if user == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user == "" {
+		s.user = user
+	}
+}
+
+// SetDestination records the requested destination and the one it was rewritten to, which are the same if it wasn't.
+func (s *Session) SetDestination(destination, rewritten string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destination = destination
+	if rewritten != destination {
+		s.rewrittenDestination = rewritten
+	}
+}
+
+// SetRule records the decision of rules on the request.
+func (s *Session) SetRule(rule string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rule = rule
+}
+
+// SetCloseReason records why the connection is closed. only the first reason is kept, as what follows is usually a
+// consequence of it.
+func (s *Session) SetCloseReason(reason string) {
+	if s == nil || reason == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closeReason == "" {
+		s.closeReason = reason
+	}
+}
+
+// counters returns counters of bytes received from and sent to the client, or nils.
+func (s *Session) counters() (in, out *atomic.Int64) {
+	if s == nil {
+		return nil, nil
+	}
+	return &s.bytesIn, &s.bytesOut
+}
+
+// isTracked tells whether the session is listed among live connections.
+func (s *Session) isTracked() bool {
+	return s != nil && s.tracked
+}
+
+func (s *Session) addCopies(n int) {
+	if s != nil {
+		s.copies.Add(n)
+	}
+}
+
+func (s *Session) doneCopy() {
+	if s != nil {
+		s.copies.Done()
+	}
+}
+
+// close closes the connection, for reason.
+func (s *Session) close(reason string) {
+	s.SetCloseReason(reason)
+	_ = s.conn.Close()
+}
+
+// Record returns what's known about the connection so far.
+func (s *Session) Record() AccessRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return AccessRecord{
+		ID:                   s.id,
+		Start:                s.start,
+		Tunnel:               s.tunnel,
+		Client:               s.client,
+		User:                 s.user,
+		Destination:          s.destination,
+		RewrittenDestination: s.rewrittenDestination,
+		Rule:                 s.rule,
+		BytesIn:              s.bytesIn.Load(),
+		BytesOut:             s.bytesOut.Load(),
+		DurationMs:           time.Since(s.start).Milliseconds(),
+		CloseReason:          s.closeReason,
+	}
+}
+
+// CountConn returns conn counting bytes read from it as received from the client, and those written to it as sent to
+// the client. it's for handlers which copy data themselves, and returns conn as is if there is no session.
+func (s *Session) CountConn(conn net.Conn) net.Conn {
+	if s == nil {
+		return conn
+	}
+	return &countingConn{Conn: conn, session: s}
+}
+
+type countingConn struct {
+	net.Conn
+	session *Session
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.session.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.session.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// countingWriter counts bytes written, for copies going through a buffer.
+type countingWriter struct {
+	io.Writer
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+// sessionConn carries the session of a connection to its handler, without getting in the way of copying.
+type sessionConn struct {
+	net.Conn
+	session *Session
+}
+
+func (c *sessionConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+func (c *sessionConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *sessionConn) PassthroughConn() net.Conn {
+	return c.Conn
+}
+
+// SessionFromConn returns the session of conn, looking through wrappers around it. it returns nil if there is none.
+func SessionFromConn(conn net.Conn) *Session {
+	for conn != nil {
+		if c, ok := conn.(*sessionConn); ok {
+			return c.session
+		}
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+	return nil
+}
+
+// AccessLog writes a JSON line for each proxied connection once it's closed.
+type AccessLog struct {
+	file *utils.RotatingFile
+}
+
+// NewAccessLog appends to the file at path, rotating it once it grows beyond maxSize, keeping maxBackups of the old
+// ones. maxSize of zero never rotates.
+func NewAccessLog(path string, maxSize int64, maxBackups int) (*AccessLog, error) {
+	file, err := utils.NewRotatingFile(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{file: file}, nil
+}
+
+func (l *AccessLog) Log(record AccessRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+func (l *AccessLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/hadi77ir/go-logging"
+	"github.com/hadi77ir/wsproxy/pkg/wsconn"
+)
+
+type compressedConn interface {
+	Compressed() bool
+	Stats() wsconn.Stats
+}
+
+// logCompressionStats reports the achieved compression ratio of a WebSocket connection, if compression was enabled.
+func logCompressionStats(logger logging.Logger, conn net.Conn) {
+	c, ok := findCompressedConn(conn)
+	if !ok || !c.Compressed() {
+		return
+	}
+	stats := c.Stats()
+	logger.Log(logging.DebugLevel, "Compression stats for", conn.RemoteAddr(),
+		"messages in/out:", stats.MessageBytesIn, "/", stats.MessageBytesOut,
+		"wire in/out:", stats.WireBytesIn, "/", stats.WireBytesOut,
+		"ratio:", fmt.Sprintf("%.2f", stats.Ratio()))
+}
+
+func findCompressedConn(conn net.Conn) (compressedConn, bool) {
+	for conn != nil {
+		if c, ok := conn.(compressedConn); ok {
+			return c, true
+		}
+		unwrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = unwrapper.NetConn()
+	}
+	return nil, false
+}
@@ -42,6 +42,7 @@ func ParseCredentials(params url.Values) ([]socks5.Authenticator, socks5.Credent
 			}
 			credentials[username] = password
 		}
+		authenticationEnabled = true
 	}
 
 	if authenticationEnabled {
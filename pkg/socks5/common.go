@@ -4,6 +4,7 @@ import (
 	"github.com/armon/go-socks5"
 	"github.com/hadi77ir/wsproxy/pkg/errors"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -21,3 +22,13 @@ func ParseAddrSpec(host string, port string) (*socks5.AddrSpec, error) {
 	}
 	return &socks5.AddrSpec{IP: ip, Port: portParsed}, nil
 }
+
+// FormatAddrSpec formats addrSpec as "HOST:PORT", where host is the domain name if there is one, rather than the
+// address it was resolved to.
+func FormatAddrSpec(addrSpec *socks5.AddrSpec) string {
+	host := addrSpec.FQDN
+	if host == "" {
+		host = addrSpec.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addrSpec.Port))
+}
@@ -1,9 +1,13 @@
 package socks5
 
 import (
+	"context"
 	"github.com/armon/go-socks5"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
 	"github.com/hadi77ir/wsproxy/pkg/utils"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -11,7 +15,13 @@ func ParseConfig(params url.Values) (*socks5.Config, error) {
 	config := &socks5.Config{
 		Logger: nil,
 	}
-	var err error
+	// outbound connections originate from the same address, interface or mark as other dials.
+	dialer, err := N.NewNetDialer("tcp", params)
+	if err != nil {
+		return nil, err
+	}
+	// and host names are resolved as they would be for other dials.
+	config.Resolver = paramsResolver{params: params}
 	config.AuthMethods, config.Credentials, err = ParseCredentials(params)
 	if err != nil {
 		return nil, err
@@ -45,10 +55,64 @@ func ParseConfig(params url.Values) (*socks5.Config, error) {
 			return nil, err
 		}
 	}
+	config.Dial = (&resolvedDialer{dialer: dialer, rules: config.Rules}).DialContext
 
 	return config, nil
 }
 
+// resolvedKey keys the addresses a requested name is resolved to, in the context of the request.
+type resolvedKey struct{}
+
+type resolvedAddrs struct {
+	name string
+	ips  []net.IP
+}
+
+// paramsResolver resolves with the resolver set in "tcp.resolver". the first address, of the family set in
+// "tcp.prefer", is the one checked by rules. all of them are kept in the context, for resolvedDialer to fall back to.
+type paramsResolver struct {
+	params url.Values
+}
+
+func (r paramsResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	ips, err := N.ResolveHost(ctx, name, r.params)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, resolvedKey{}, &resolvedAddrs{name: name, ips: ips}), ips[0], nil
+}
+
+// resolvedDialer dials requested names on all the addresses they are resolved to, with Happy Eyeballs, skipping those
+// which rules block. destinations given as addresses, or rewritten, are dialed as they are.
+type resolvedDialer struct {
+	dialer *net.Dialer
+	rules  socks5.RuleSet
+}
+
+func (d *resolvedDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	resolved, _ := ctx.Value(resolvedKey{}).(*resolvedAddrs)
+	host, port, err := net.SplitHostPort(addr)
+	if resolved == nil || err != nil || !resolved.ips[0].Equal(net.ParseIP(host)) {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	// the first address has been allowed already.
+	ips := append(make([]net.IP, 0, len(resolved.ips)), resolved.ips[0])
+	for _, ip := range resolved.ips[1:] {
+		request := &socks5.Request{
+			Command:  socks5.ConnectCommand,
+			DestAddr: &socks5.AddrSpec{FQDN: resolved.name, IP: ip, Port: portNum},
+		}
+		if _, allowed := d.rules.Allow(ctx, request); allowed {
+			ips = append(ips, ip)
+		}
+	}
+	return N.DialAddresses(ctx, d.dialer, network, ips, port)
+}
+
 func ParseRewrites(filePath string) (socks5.AddressRewriter, error) {
 	fileBytes, err := utils.ReadFile(filePath)
 	if err != nil {
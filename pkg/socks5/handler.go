@@ -3,36 +3,207 @@ package socks5
 import (
 	"github.com/armon/go-socks5"
 	"github.com/hadi77ir/go-logging"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
 	"github.com/hadi77ir/wsproxy/pkg/proxy"
 	"github.com/hadi77ir/wsproxy/pkg/utils"
+	"golang.org/x/net/context"
 	"net"
 	"net/url"
 	"sync"
 )
 
+const (
+	ParamUserUploadRate   = "socks5.user_upload_rate"
+	ParamUserDownloadRate = "socks5.user_download_rate"
+)
+
 func init() {
-	proxy.HandlerCreators.Register("socks5", CreateSocks5Handler)
+	proxy.RuleCountingHandlerCreators.Register("socks5", createSocks5Handler)
 }
 
 func CreateSocks5Handler(addr string, transportParams url.Values) (proxy.ConnHandlerFunc, error) {
+	handler, _, err := createSocks5Handler(addr, transportParams)
+	return handler, err
+}
+
+// createSocks5Handler creates the handler and tells how many rules, rewrites, users and quotas it has loaded.
+func createSocks5Handler(addr string, transportParams url.Values) (proxy.ConnHandlerFunc, map[string]int, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	conf, err := ParseConfig(utils.MergeParams(u.Query(), transportParams))
+	params := utils.MergeParams(u.Query(), transportParams)
+	conf, err := ParseConfig(params)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	server, err := socks5.New(conf)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	limits := newUserLimits(params)
+	quotas, err := newQuotaTracker(params)
+	if err != nil {
+		return nil, nil, err
+	}
 	return func(incoming net.Conn, logger logging.Logger, wg *sync.WaitGroup, done <-chan struct{}) {
-		if err := server.ServeConn(incoming); err != nil {
+		session := proxy.SessionFromConn(incoming)
+		if session == nil && limits == nil && quotas == nil {
+			if err := server.ServeConn(incoming); err != nil {
+				logger.Log(logging.ErrorLevel, "Error serving connection:", err)
+			}
+			return
+		}
+		// user is known once the request is checked against rules, so a server is made for each connection with rules
+		// that apply the limits and quota of that user to it, and record the request in the session.
+		rules := &userRules{RuleSet: conf.Rules, logger: logger, session: session}
+		conn := session.CountConn(incoming)
+		if limits != nil {
+			rules.limits = limits
+			rules.limited = N.NewRateLimitedConn(conn, nil, nil)
+			conn = rules.limited
+		}
+		if quotas != nil {
+			quotas.serving.Add(1)
+			defer quotas.serving.Done()
+			quotas.start(logger, wg, done)
+			rules.quotas = quotas
+			rules.counted = &quotaConn{Conn: conn, tracker: quotas}
+			conn = rules.counted
+		}
+		connConf := *conf
+		connConf.Rules = rules
+		if session != nil {
+			connConf.Rewriter = &sessionRewriter{AddressRewriter: conf.Rewriter, session: session}
+		}
+		connServer, err := socks5.New(&connConf)
+		if err != nil {
+			logger.Log(logging.ErrorLevel, "Error serving connection:", err)
+			return
+		}
+		if err := connServer.ServeConn(conn); err != nil {
 			logger.Log(logging.ErrorLevel, "Error serving connection:", err)
+			session.SetCloseReason("socks5: " + err.Error())
+		}
+	}, countRules(conf, quotas), nil
+}
+
+func countRules(conf *socks5.Config, quotas *quotaTracker) map[string]int {
+	counts := map[string]int{"ruleset": 0, "rewrites": 0, "users": 0, "quotas": 0}
+	if ruleset, ok := conf.Rules.(*Ruleset); ok {
+		counts["ruleset"] = len(ruleset.Rules)
+	}
+	if rewriter, ok := conf.Rewriter.(*Rewriter); ok {
+		counts["rewrites"] = len(rewriter.Rules)
+	}
+	if credentials, ok := conf.Credentials.(socks5.StaticCredentials); ok {
+		counts["users"] = len(credentials)
+	}
+	if quotas != nil {
+		counts["quotas"] = len(quotas.quotas)
+	}
+	return counts
+}
+
+// userLimits holds bandwidth buckets of each authenticated user, shared by all of their connections.
+type userLimits struct {
+	uploadRate   int64
+	downloadRate int64
+	mu           sync.Mutex
+	buckets      map[string][2]*utils.TokenBucket
+}
+
+// newUserLimits returns nil if no rates are set.
+func newUserLimits(params url.Values) *userLimits {
+	uploadRate := utils.SizeFromParameters(params, ParamUserUploadRate, 0)
+	downloadRate := utils.SizeFromParameters(params, ParamUserDownloadRate, 0)
+	if uploadRate <= 0 && downloadRate <= 0 {
+		return nil
+	}
+	return &userLimits{
+		uploadRate:   uploadRate,
+		downloadRate: downloadRate,
+		buckets:      make(map[string][2]*utils.TokenBucket),
+	}
+}
+
+func (l *userLimits) get(username string) (upload, download *utils.TokenBucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buckets, found := l.buckets[username]
+	if !found {
+		if l.uploadRate > 0 {
+			buckets[0] = utils.NewTokenBucket(float64(l.uploadRate), int(l.uploadRate))
+		}
+		if l.downloadRate > 0 {
+			buckets[1] = utils.NewTokenBucket(float64(l.downloadRate), int(l.downloadRate))
+		}
+		l.buckets[username] = buckets
+	}
+	return buckets[0], buckets[1]
+}
+
+// userRules applies limits and quota of the authenticated user to the connection before checking its request, and
+// records the user and the decision in the session.
+type userRules struct {
+	socks5.RuleSet
+	logger  logging.Logger
+	session *proxy.Session
+	limits  *userLimits
+	limited *N.RateLimitedConn
+	quotas  *quotaTracker
+	counted *quotaConn
+}
+
+func (r *userRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.AuthContext != nil {
+		if username, found := req.AuthContext.Payload["Username"]; found {
+			r.session.SetUser(username)
+			if r.limits != nil {
+				r.limited.SetLimits(r.limits.get(username))
+			}
+			if r.quotas != nil {
+				if err := r.quotas.admit(username, r.counted); err != nil {
+					r.logger.Log(logging.WarnLevel, "Refused request of user", username, err)
+					r.session.SetRule("block by quota")
+					r.session.SetCloseReason(err.Error())
+					return ctx, false
+				}
+			}
 		}
-	}, nil
+	}
+	if ruleset, ok := r.RuleSet.(*Ruleset); ok {
+		allowed, decision := ruleset.Decide(req)
+		r.session.SetRule(decision)
+		return ctx, allowed
+	}
+	ctx, allowed := r.RuleSet.Allow(ctx, req)
+	if allowed {
+		r.session.SetRule("allow")
+	} else {
+		r.session.SetRule("block")
+	}
+	return ctx, allowed
 }
+
+var _ socks5.RuleSet = &userRules{}
+
+// sessionRewriter records the requested destination and the one it's rewritten to in the session.
+type sessionRewriter struct {
+	socks5.AddressRewriter
+	session *proxy.Session
+}
+
+func (r *sessionRewriter) Rewrite(ctx context.Context, request *socks5.Request) (context.Context, *socks5.AddrSpec) {
+	destAddr := request.DestAddr
+	if r.AddressRewriter != nil {
+		ctx, destAddr = r.AddressRewriter.Rewrite(ctx, request)
+	}
+	r.session.SetDestination(FormatAddrSpec(request.DestAddr), FormatAddrSpec(destAddr))
+	return ctx, destAddr
+}
+
+var _ socks5.AddressRewriter = &sessionRewriter{}
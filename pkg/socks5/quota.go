@@ -0,0 +1,426 @@
+package socks5
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamQuota             = "socks5.quota"
+	ParamQuotaState        = "socks5.quota_state"
+	ParamQuotaSaveInterval = "socks5.quota_save_interval"
+
+	defaultQuotaSaveInterval = time.Duration(30) * time.Second
+
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Quota is how many bytes, sent and received, a user may transfer each day and each month. zero is unlimited.
+type Quota struct {
+	Daily   int64
+	Monthly int64
+}
+
+// ParseQuota parses "daily=SIZE,monthly=SIZE", where either part may be left out.
+func ParseQuota(spec string) (Quota, error) {
+	quota := Quota{}
+	for _, part := range strings.Split(spec, ",") {
+		period, size, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return quota, E.ErrInvalidSyntax
+		}
+		parsed, err := utils.ParseSize(size)
+		if err != nil || parsed < 0 {
+			return quota, E.ErrInvalidSyntax
+		}
+		switch strings.ToLower(period) {
+		case "daily":
+			quota.Daily = parsed
+		case "monthly":
+			quota.Monthly = parsed
+		default:
+			return quota, E.ErrInvalidSyntax
+		}
+	}
+	return quota, nil
+}
+
+// ParseQuotas reads "USERNAME:QUOTA" lines in "socks5.quota".
+func ParseQuotas(params url.Values) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+	if quotaPath, found := utils.GetParameter(params, ParamQuota); found {
+		fileBytes, err := utils.ReadFile(quotaPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(fileBytes), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "#") || len(line) == 0 {
+				continue
+			}
+			username, spec, found := strings.Cut(line, ":")
+			if !found || len(username) == 0 {
+				return nil, E.ErrInvalidSyntax
+			}
+			quota, err := ParseQuota(spec)
+			if err != nil {
+				return nil, err
+			}
+			quotas[username] = quota
+		}
+	}
+	return quotas, nil
+}
+
+// UserUsage is how many bytes a user has transferred in the current day and month. Generation is increased whenever
+// usage is reset, which tells running instances to drop what they have counted before that.
+type UserUsage struct {
+	Generation uint64 `json:"generation"`
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// Roll starts over counting periods which have passed.
+func (u *UserUsage) Roll(now time.Time) {
+	if day := now.Format(dayLayout); u.Day != day {
+		u.Day = day
+		u.DayBytes = 0
+	}
+	if month := now.Format(monthLayout); u.Month != month {
+		u.Month = month
+		u.MonthBytes = 0
+	}
+}
+
+// Reset clears usage of the user.
+func (u *UserUsage) Reset() {
+	u.Generation++
+	u.DayBytes = 0
+	u.MonthBytes = 0
+}
+
+func (u *UserUsage) Exhausted(quota Quota) bool {
+	return (quota.Daily > 0 && u.DayBytes >= quota.Daily) || (quota.Monthly > 0 && u.MonthBytes >= quota.Monthly)
+}
+
+type QuotaState struct {
+	Users map[string]*UserUsage `json:"users"`
+}
+
+// LoadQuotaState reads the state file at path. a missing file is an empty state.
+func LoadQuotaState(path string) (*QuotaState, error) {
+	state := &QuotaState{}
+	fileBytes, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(fileBytes, state); err != nil {
+			return nil, err
+		}
+	}
+	if state.Users == nil {
+		state.Users = make(map[string]*UserUsage)
+	}
+	return state, nil
+}
+
+// UpdateQuotaState loads the state at path, lets update change it and saves it if update returns true. the state is
+// locked meanwhile, so that running instances and the quota command don't overwrite each other's changes.
+func UpdateQuotaState(path string, update func(state *QuotaState) (bool, error)) (*QuotaState, error) {
+	// the state file itself is replaced on each save, so the lock is taken on a file next to it.
+	lock, err := utils.LockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	state, err := LoadQuotaState(path)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := update(state)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err = state.Save(path); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// Save writes the state to path, replacing the file at once so that it's never seen half written.
+func (s *QuotaState) Save(path string) error {
+	fileBytes, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err = temp.Write(fileBytes); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// quotaTracker counts bytes transferred by authenticated users and closes their connections once their quota is
+// exhausted. usage is merged into the state file periodically, which keeps resets and usage counted by other
+// instances sharing the file.
+type quotaTracker struct {
+	path         string
+	quotas       map[string]Quota
+	saveInterval time.Duration
+	logger       logging.Logger
+	startOnce    sync.Once
+	mu           sync.Mutex
+	state        *QuotaState
+	// bytes counted since the last save.
+	pending map[string]int64
+	conns   map[string]map[*quotaConn]struct{}
+	// connections being served, which may still be counting.
+	serving sync.WaitGroup
+}
+
+// newQuotaTracker returns nil if no quotas are set.
+func newQuotaTracker(params url.Values) (*quotaTracker, error) {
+	quotas, err := ParseQuotas(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	path, found := utils.GetParameter(params, ParamQuotaState)
+	if !found || path == "" {
+		return nil, E.ErrMissingPart("socks5 quotas need " + ParamQuotaState)
+	}
+	state, err := LoadQuotaState(path)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaTracker{
+		path:         path,
+		quotas:       quotas,
+		saveInterval: utils.DurationFromParameters(params, ParamQuotaSaveInterval, defaultQuotaSaveInterval),
+		state:        state,
+		pending:      make(map[string]int64),
+		conns:        make(map[string]map[*quotaConn]struct{}),
+	}, nil
+}
+
+func (t *quotaTracker) usage(username string) *UserUsage {
+	usage, found := t.state.Users[username]
+	if !found {
+		usage = &UserUsage{}
+		t.state.Users[username] = usage
+	}
+	usage.Roll(time.Now())
+	return usage
+}
+
+// admit starts counting conn toward quota of username, unless it's exhausted already.
+func (t *quotaTracker) admit(username string, conn *quotaConn) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.usage(username).Exhausted(t.quotas[username]) {
+		return E.ErrQuotaExhausted
+	}
+	conns, found := t.conns[username]
+	if !found {
+		conns = make(map[*quotaConn]struct{})
+		t.conns[username] = conns
+	}
+	conns[conn] = struct{}{}
+	conn.username.Store(&username)
+	return nil
+}
+
+func (t *quotaTracker) release(username string, conn *quotaConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if conns, found := t.conns[username]; found {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(t.conns, username)
+		}
+	}
+}
+
+func (t *quotaTracker) add(username string, n int) {
+	t.mu.Lock()
+	usage := t.usage(username)
+	usage.DayBytes += int64(n)
+	usage.MonthBytes += int64(n)
+	t.pending[username] += int64(n)
+	toClose := t.exhaust(username, usage)
+	t.mu.Unlock()
+	t.closeConns(username, toClose)
+}
+
+// exhaust returns connections of username to close, if its quota is exhausted.
+func (t *quotaTracker) exhaust(username string, usage *UserUsage) []*quotaConn {
+	if !usage.Exhausted(t.quotas[username]) {
+		return nil
+	}
+	toClose := make([]*quotaConn, 0, len(t.conns[username]))
+	for conn := range t.conns[username] {
+		toClose = append(toClose, conn)
+	}
+	delete(t.conns, username)
+	return toClose
+}
+
+func (t *quotaTracker) closeConns(username string, toClose []*quotaConn) {
+	if len(toClose) == 0 {
+		return
+	}
+	t.logger.Log(logging.WarnLevel, "Quota of user", username, "is exhausted, closing", len(toClose), "connections")
+	for _, conn := range toClose {
+		_ = conn.Conn.Close()
+	}
+}
+
+// save merges usage counted since the last save into the state file. users whose generation in the file is newer
+// were reset, so usage counted before that is dropped. connections of users whose quota got exhausted by other
+// instances are closed.
+func (t *quotaTracker) save() error {
+	t.mu.Lock()
+	fileState, err := t.merge()
+	toClose := make(map[string][]*quotaConn)
+	if err == nil {
+		for username, usage := range fileState.Users {
+			if conns := t.exhaust(username, usage); len(conns) > 0 {
+				toClose[username] = conns
+			}
+		}
+	}
+	t.mu.Unlock()
+	for username, conns := range toClose {
+		t.closeConns(username, conns)
+	}
+	return err
+}
+
+func (t *quotaTracker) merge() (*QuotaState, error) {
+	fileState, err := UpdateQuotaState(t.path, func(fileState *QuotaState) (bool, error) {
+		now := time.Now()
+		for username, usage := range t.state.Users {
+			fileUsage, found := fileState.Users[username]
+			if !found {
+				fileState.Users[username] = usage
+				continue
+			}
+			fileUsage.Roll(now)
+			if fileUsage.Generation == usage.Generation {
+				fileUsage.DayBytes += t.pending[username]
+				fileUsage.MonthBytes += t.pending[username]
+			}
+		}
+		return len(t.pending) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.state = fileState
+	t.pending = make(map[string]int64)
+	return fileState, nil
+}
+
+// start saves the state periodically until done is closed, logging to logger, which is also used for closed
+// connections. it's saved once more when connections being served have ended after that, which wg waits for.
+func (t *quotaTracker) start(logger logging.Logger, wg *sync.WaitGroup, done <-chan struct{}) {
+	t.startOnce.Do(func() {
+		t.logger = logger
+		wg.Add(1)
+		go t.run(wg, done)
+	})
+}
+
+func (t *quotaTracker) run(wg *sync.WaitGroup, done <-chan struct{}) {
+	defer wg.Done()
+	ticker := time.NewTicker(t.saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			// don't lose what's counted since the last save when shutting down. shutdown may not wait for connections
+			// still being served, so it's saved before they end too.
+			t.logSave()
+			t.serving.Wait()
+			t.logSave()
+			return
+		case <-ticker.C:
+			t.logSave()
+		}
+	}
+}
+
+func (t *quotaTracker) logSave() {
+	if err := t.save(); err != nil {
+		t.logger.Log(logging.ErrorLevel, "Failed to save quota state:", err)
+	}
+}
+
+// quotaConn counts bytes read and written toward quota of its user, once it's admitted.
+type quotaConn struct {
+	net.Conn
+	tracker  *quotaTracker
+	username atomic.Pointer[string]
+}
+
+func (c *quotaConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if username := c.username.Load(); username != nil && n > 0 {
+		c.tracker.add(*username, n)
+	}
+	return n, err
+}
+
+func (c *quotaConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if username := c.username.Load(); username != nil && n > 0 {
+		c.tracker.add(*username, n)
+	}
+	return n, err
+}
+
+func (c *quotaConn) Close() error {
+	if username := c.username.Load(); username != nil {
+		c.tracker.release(*username, c)
+	}
+	return c.Conn.Close()
+}
+
+func (c *quotaConn) CloseWrite() error {
+	return N.CloseWrite(c.Conn)
+}
+
+func (c *quotaConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &quotaConn{}
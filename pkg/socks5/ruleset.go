@@ -64,13 +64,27 @@ type Ruleset struct {
 }
 
 func (r *Ruleset) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
-	for _, rule := range r.Rules {
+	allowed, _ := r.Decide(req)
+	return ctx, allowed
+}
+
+// Decide checks the request against rules, and also describes the decision, e.g. "allow by rule 2", counting rules
+// from one, or "block by default".
+func (r *Ruleset) Decide(req *socks5.Request) (bool, string) {
+	for i, rule := range r.Rules {
 		if action, matched := rule.Match(req.DestAddr); matched {
-			return ctx, action == ActionAllow
+			return action == ActionAllow, describeAction(action) + " by rule " + strconv.Itoa(i+1)
 		}
 	}
 
-	return ctx, r.DefaultAction == ActionAllow
+	return r.DefaultAction == ActionAllow, describeAction(r.DefaultAction) + " by default"
+}
+
+func describeAction(action RuleAction) string {
+	if action == ActionAllow {
+		return "allow"
+	}
+	return "block"
 }
 
 var _ socks5.RuleSet = &Ruleset{}
@@ -99,8 +113,8 @@ func createPortMatcherSingle(portsDef string) (PortMatcherFunc, error) {
 	if strings.HasPrefix(portsDef, "^") {
 		// negate
 		matchedTrue = false
+		portsDef = portsDef[1:]
 	}
-	portsDef = portsDef[1:]
 
 	if portsDef == "*" {
 		return func(_ int) bool {
@@ -127,7 +141,7 @@ func createPortMatcherSingle(portsDef string) (PortMatcherFunc, error) {
 		}, nil
 	}
 
-	exactPort, err := ParsePort(max)
+	exactPort, err := ParsePort(portsDef)
 	if err != nil {
 		return nil, err
 	}
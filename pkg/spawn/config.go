@@ -0,0 +1,50 @@
+package spawn
+
+import (
+	"net/url"
+	"time"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamCommand          = "exec.command"
+	ParamArg              = "exec.arg"
+	ParamDir              = "exec.dir"
+	ParamMaxProcs         = "exec.max_procs"
+	ParamKillTimeout      = "exec.kill_timeout"
+	ParamHalfCloseTimeout = "exec.half_close_timeout"
+
+	defaultKillTimeout      = time.Duration(5) * time.Second
+	defaultHalfCloseTimeout = time.Duration(30) * time.Second
+)
+
+type Config struct {
+	Command string
+	Args    []string
+	Dir     string
+	// MaxProcs limits number of concurrently running commands. zero means no limit.
+	MaxProcs int
+	// KillTimeout is how long to wait for the command to exit after client has disconnected.
+	KillTimeout time.Duration
+	// HalfCloseTimeout is how long the command may keep running after the client has closed writing.
+	HalfCloseTimeout time.Duration
+}
+
+// ParseConfig reads configuration of the command from "exec://" URL, where path of the URL is path of the command,
+// and parameters. "exec.command" overrides the path.
+func ParseConfig(u *url.URL, params url.Values) (*Config, error) {
+	config := &Config{
+		Command:          utils.StringFromParameters(params, ParamCommand, u.Path),
+		Args:             params[ParamArg],
+		Dir:              utils.StringFromParameters(params, ParamDir, ""),
+		MaxProcs:         utils.IntegerFromParameters(params, ParamMaxProcs, 0),
+		KillTimeout:      utils.DurationFromParameters(params, ParamKillTimeout, defaultKillTimeout),
+		HalfCloseTimeout: utils.DurationFromParameters(params, ParamHalfCloseTimeout, defaultHalfCloseTimeout),
+	}
+	if config.Command == "" {
+		return nil, E.ErrMissingPart("command")
+	}
+	return config, nil
+}
@@ -0,0 +1,154 @@
+package spawn
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/hadi77ir/go-logging"
+	N "github.com/hadi77ir/wsproxy/pkg/net"
+	"github.com/hadi77ir/wsproxy/pkg/proxy"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+func init() {
+	proxy.HandlerCreators.Register("exec", CreateExecHandler)
+}
+
+// CreateExecHandler creates a handler which spawns a command for each connection, wiring its standard input and
+// output to the connection.
+func CreateExecHandler(addr string, transportParams url.Values) (proxy.ConnHandlerFunc, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ParseConfig(u, utils.MergeParams(u.Query(), transportParams))
+	if err != nil {
+		return nil, err
+	}
+
+	var slots chan struct{}
+	if config.MaxProcs > 0 {
+		slots = make(chan struct{}, config.MaxProcs)
+	}
+
+	return func(incoming net.Conn, logger logging.Logger, wg *sync.WaitGroup, done <-chan struct{}) {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				logger.Log(logging.WarnLevel, "Too many running commands, rejecting connection from", incoming.RemoteAddr())
+				proxy.SessionFromConn(incoming).SetCloseReason("rejected: too many running commands")
+				return
+			}
+		}
+		serveCommand(config, incoming, logger, wg, done)
+	}, nil
+}
+
+func serveCommand(config *Config, incoming net.Conn, logger logging.Logger, wg *sync.WaitGroup, done <-chan struct{}) {
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Dir = config.Dir
+	cmd.Env = append(os.Environ(), commandEnv(incoming)...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.Log(logging.ErrorLevel, "Failed to create pipe:", err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		logger.Log(logging.ErrorLevel, "Failed to create pipe:", err)
+		return
+	}
+	session := proxy.SessionFromConn(incoming)
+	if err = cmd.Start(); err != nil {
+		logger.Log(logging.ErrorLevel, "Failed to start", config.Command, err)
+		session.SetCloseReason("failed to start: " + err.Error())
+		return
+	}
+	counted := session.CountConn(incoming)
+
+	clientGone := make(chan struct{})
+	outputDone := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := io.Copy(stdin, counted)
+		_ = stdin.Close()
+		if err == nil {
+			// on EOF, the client has either closed writing or gone away, which can't be told apart on every transport.
+			// the command gets EOF on its input and may still respond, but it's killed if it takes too long.
+			timer := time.NewTimer(config.HalfCloseTimeout)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-outputDone:
+				return
+			case <-done:
+				return
+			}
+		}
+		close(clientGone)
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(outputDone)
+		_, _ = io.Copy(counted, stdout)
+	}()
+
+	exited := make(chan error, 1)
+	select {
+	case <-outputDone:
+		// command has closed its output, it is probably exiting.
+		session.SetCloseReason("command exited")
+		go func() { exited <- cmd.Wait() }()
+		select {
+		case err = <-exited:
+		case <-time.After(config.KillTimeout):
+			_ = cmd.Process.Kill()
+			err = <-exited
+		}
+	case <-clientGone:
+		session.SetCloseReason("client closed")
+		err = kill(cmd, outputDone, config.KillTimeout)
+	case <-done:
+		session.SetCloseReason("shutdown")
+		err = kill(cmd, outputDone, config.KillTimeout)
+	}
+	if err != nil {
+		logger.Log(logging.DebugLevel, "Command", config.Command, "exited:", err)
+	}
+}
+
+// kill kills the command and waits for it. Wait closes the output pipe, so what's left in it is copied first, unless
+// it's held open for longer than timeout, e.g. by a child of the command.
+func kill(cmd *exec.Cmd, outputDone <-chan struct{}, timeout time.Duration) error {
+	_ = cmd.Process.Kill()
+	select {
+	case <-outputDone:
+	case <-time.After(timeout):
+	}
+	return cmd.Wait()
+}
+
+// commandEnv describes the client to the command, in the spirit of CGI variables.
+func commandEnv(incoming net.Conn) []string {
+	env := []string{
+		"WSPROXY_REMOTE_ADDR=" + incoming.RemoteAddr().String(),
+		"WSPROXY_LOCAL_ADDR=" + incoming.LocalAddr().String(),
+	}
+	if host, port, err := net.SplitHostPort(incoming.RemoteAddr().String()); err == nil {
+		env = append(env, "WSPROXY_REMOTE_HOST="+host, "WSPROXY_REMOTE_PORT="+port)
+	}
+	if user := N.AuthenticatedUser(incoming); user != "" {
+		env = append(env, "WSPROXY_USER="+user)
+	}
+	return env
+}
@@ -0,0 +1,96 @@
+package udpconn
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// datagrams are carried over stream transports with a 2-byte big-endian length prefix.
+const frameHeaderSize = 2
+const maxDatagramSize = 65535
+
+type packetIO interface {
+	readPacket(b []byte) (int, error)
+	writePacket(b []byte) (int, error)
+	close() error
+	localAddr() net.Addr
+	remoteAddr() net.Addr
+	setReadDeadline(t time.Time) error
+	setWriteDeadline(t time.Time) error
+}
+
+// Conn exposes a flow of datagrams as a stream of length-prefixed frames. what is read from it can be written to a
+// stream transport as-is, and what is received from the stream transport can be written to it, in any chunk sizes.
+type Conn struct {
+	pio      packetIO
+	readBuf  []byte
+	pending  []byte
+	writeBuf []byte
+}
+
+func (c *Conn) Read(b []byte) (n int, err error) {
+	if len(c.pending) == 0 {
+		n, err = c.pio.readPacket(c.readBuf[frameHeaderSize:])
+		if err != nil {
+			return 0, err
+		}
+		binary.BigEndian.PutUint16(c.readBuf, uint16(n))
+		c.pending = c.readBuf[:frameHeaderSize+n]
+	}
+	n = copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.writeBuf = append(c.writeBuf, b...)
+	for len(c.writeBuf) >= frameHeaderSize {
+		size := int(binary.BigEndian.Uint16(c.writeBuf))
+		if len(c.writeBuf) < frameHeaderSize+size {
+			break
+		}
+		if _, err = c.pio.writePacket(c.writeBuf[frameHeaderSize : frameHeaderSize+size]); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[frameHeaderSize+size:]
+	}
+	if len(c.writeBuf) == 0 {
+		// release memory of large frames
+		c.writeBuf = nil
+	}
+	return len(b), nil
+}
+
+func (c *Conn) Close() error {
+	return c.pio.close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pio.localAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.pio.remoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.pio.setReadDeadline(t); err != nil {
+		return err
+	}
+	return c.pio.setWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.pio.setReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.pio.setWriteDeadline(t)
+}
+
+var _ net.Conn = &Conn{}
+
+func newConn(pio packetIO) *Conn {
+	return &Conn{pio: pio, readBuf: make([]byte, frameHeaderSize+maxDatagramSize)}
+}
@@ -0,0 +1,48 @@
+package udpconn
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type dialedPacketIO struct {
+	conn *net.UDPConn
+}
+
+func (d *dialedPacketIO) readPacket(b []byte) (int, error) {
+	return d.conn.Read(b)
+}
+
+func (d *dialedPacketIO) writePacket(b []byte) (int, error) {
+	return d.conn.Write(b)
+}
+
+func (d *dialedPacketIO) close() error {
+	return d.conn.Close()
+}
+
+func (d *dialedPacketIO) localAddr() net.Addr {
+	return d.conn.LocalAddr()
+}
+
+func (d *dialedPacketIO) remoteAddr() net.Addr {
+	return d.conn.RemoteAddr()
+}
+
+func (d *dialedPacketIO) setReadDeadline(t time.Time) error {
+	return d.conn.SetReadDeadline(t)
+}
+
+func (d *dialedPacketIO) setWriteDeadline(t time.Time) error {
+	return d.conn.SetWriteDeadline(t)
+}
+
+// Dial creates a connected UDP socket, framing datagrams it receives and sending frames written to it as datagrams.
+func Dial(ctx context.Context, dialer *net.Dialer, host string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(&dialedPacketIO{conn: conn.(*net.UDPConn)}), nil
+}
@@ -0,0 +1,256 @@
+package udpconn
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const sessionQueueSize = 64
+
+// Listener demultiplexes datagrams received on a packet socket into sessions, one per source address.
+// each session is returned from Accept as a connection.
+type Listener struct {
+	conn        net.PacketConn
+	backlog     chan *Conn
+	sessions    map[string]*session
+	mu          sync.Mutex
+	idleTimeout time.Duration
+	maxSessions int
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.backlog:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "udp", Addr: l.conn.LocalAddr(), Err: net.ErrClosed}
+	}
+}
+
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.conn.Close()
+		l.mu.Lock()
+		for _, s := range l.sessions {
+			s.closeLocked()
+		}
+		l.sessions = map[string]*session{}
+		l.mu.Unlock()
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+var _ net.Listener = &Listener{}
+
+func (l *Listener) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			_ = l.Close()
+			return
+		}
+		s := l.getSession(addr)
+		if s == nil {
+			// too many sessions. drop it.
+			continue
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		select {
+		case s.queue <- packet:
+		default:
+			// consumer isn't keeping up. as with any UDP flow, drop it.
+		}
+	}
+}
+
+func (l *Listener) getSession(addr net.Addr) *session {
+	key := addr.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, found := l.sessions[key]; found {
+		s.touch()
+		return s
+	}
+	if l.maxSessions > 0 && len(l.sessions) >= l.maxSessions {
+		return nil
+	}
+	s := &session{
+		listener: l,
+		key:      key,
+		addr:     addr,
+		queue:    make(chan []byte, sessionQueueSize),
+		closed:   make(chan struct{}),
+		// closed and replaced on each change of the read deadline, to wake pending reads up.
+		deadlineChanged: make(chan struct{}),
+	}
+	s.touch()
+	select {
+	case l.backlog <- newConn(s):
+	default:
+		// accept loop isn't keeping up.
+		return nil
+	}
+	l.sessions[key] = s
+	return s
+}
+
+func (l *Listener) expireSessions() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closed:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, s := range l.sessions {
+				if now.Sub(s.lastActivity()) > l.idleTimeout {
+					s.closeLocked()
+					delete(l.sessions, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Listener) removeSession(s *session) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current, found := l.sessions[s.key]; found && current == s {
+		delete(l.sessions, s.key)
+	}
+	s.closeLocked()
+}
+
+type session struct {
+	listener     *Listener
+	key          string
+	addr         net.Addr
+	queue        chan []byte
+	closed       chan struct{}
+	closeOnce    sync.Once
+	mu           sync.Mutex
+	active       time.Time
+	readDeadline time.Time
+	// closed and replaced when readDeadline changes, as net.Pipe does.
+	deadlineChanged chan struct{}
+}
+
+func (s *session) touch() {
+	s.mu.Lock()
+	s.active = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *session) lastActivity() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+func (s *session) readPacket(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		deadline, changed := s.readDeadline, s.deadlineChanged
+		s.mu.Unlock()
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case packet := <-s.queue:
+			stopTimer(timer)
+			return copy(b, packet), nil
+		case <-s.closed:
+			stopTimer(timer)
+			return 0, &net.OpError{Op: "read", Net: "udp", Addr: s.addr, Err: net.ErrClosed}
+		case <-timeout:
+			return 0, &net.OpError{Op: "read", Net: "udp", Addr: s.addr, Err: os.ErrDeadlineExceeded}
+		case <-changed:
+			// start over with the new deadline.
+			stopTimer(timer)
+		}
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+func (s *session) writePacket(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: s.addr, Err: net.ErrClosed}
+	default:
+	}
+	s.touch()
+	return s.listener.conn.WriteTo(b, s.addr)
+}
+
+func (s *session) close() error {
+	s.listener.removeSession(s)
+	return nil
+}
+
+func (s *session) closeLocked() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+func (s *session) localAddr() net.Addr {
+	return s.listener.conn.LocalAddr()
+}
+
+func (s *session) remoteAddr() net.Addr {
+	return s.addr
+}
+
+func (s *session) setReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	close(s.deadlineChanged)
+	s.deadlineChanged = make(chan struct{})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *session) setWriteDeadline(_ time.Time) error {
+	// writes to a packet socket don't block for long. nothing to do.
+	return nil
+}
+
+// Listen starts demultiplexing datagrams received on conn. sessions idle for more than idleTimeout are closed.
+// if maxSessions is non-zero, datagrams creating new sessions beyond that are dropped.
+func Listen(conn net.PacketConn, backlog int, idleTimeout time.Duration, maxSessions int) *Listener {
+	l := &Listener{
+		conn:        conn,
+		backlog:     make(chan *Conn, backlog),
+		sessions:    make(map[string]*session),
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		closed:      make(chan struct{}),
+	}
+	go l.serve()
+	if idleTimeout > 0 {
+		go l.expireSessions()
+	}
+	return l
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "sync"
+
+// CopyBufferSize is the size of buffers used for copying between connections, the same as io.Copy allocates.
+const CopyBufferSize = 32 * 1024
+
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, CopyBufferSize)
+		return &buf
+	},
+}
+
+// GetCopyBuffer takes a buffer from a shared pool. it has to be returned with PutCopyBuffer once it's not used anymore.
+func GetCopyBuffer() *[]byte {
+	return copyBuffers.Get().(*[]byte)
+}
+
+func PutCopyBuffer(buf *[]byte) {
+	copyBuffers.Put(buf)
+}
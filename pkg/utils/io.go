@@ -94,7 +94,9 @@ func retFalse() bool {
 }
 
 func (mr *DynamicMultiReader) WriteTo(w io.Writer) (sum int64, err error) {
-	return mr.writeToWithBuffer(w, make([]byte, 1024*32))
+	buf := GetCopyBuffer()
+	defer PutCopyBuffer(buf)
+	return mr.writeToWithBuffer(w, *buf)
 }
 
 func (mr *DynamicMultiReader) writeToWithBuffer(w io.Writer, buf []byte) (sum int64, err error) {
@@ -0,0 +1,17 @@
+package utils
+
+import "os"
+
+// LockFile takes an exclusive lock on the file at path, creating it if needed, and waits for other processes holding
+// it. the lock is released by closing the returned file. it's advisory, so only those taking it are kept out.
+func LockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package utils
+
+import "os"
+
+// files aren't locked here. concurrent updates may be lost.
+func lockFile(_ *os.File) error {
+	return nil
+}
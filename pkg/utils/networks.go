@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+)
+
+// ParseNetworks parses a list of CIDR ranges. Bare IP addresses are treated as single-host ranges.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, E.ErrInvalidSyntax
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// NetworksContain reports whether any of the networks contains the given IP address.
+func NetworksContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnixPeers, listed among trusted peers, trusts peers connected through Unix sockets, which have no address to check.
+const UnixPeers = "unix"
+
+// TrustedPeers are peers in any of Networks, and those connected through Unix sockets if Unix is set. methods report
+// nothing trusted on a nil TrustedPeers.
+type TrustedPeers struct {
+	Networks []*net.IPNet
+	Unix     bool
+}
+
+// TrustedPeersFromParameters parses a comma-separated list of networks, which may include UnixPeers. returns nil if the
+// parameter is not set.
+func TrustedPeersFromParameters(params url.Values, key string) (*TrustedPeers, error) {
+	var trusted TrustedPeers
+	var networks []string
+	for _, item := range MultiStringFromParameters(params, key, nil) {
+		if strings.TrimSpace(item) == UnixPeers {
+			trusted.Unix = true
+			continue
+		}
+		networks = append(networks, item)
+	}
+	var err error
+	trusted.Networks, err = ParseNetworks(networks)
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted.Networks) == 0 && !trusted.Unix {
+		return nil, nil
+	}
+	return &trusted, nil
+}
+
+// ContainsIP reports whether any of the networks contains the given IP address.
+func (t *TrustedPeers) ContainsIP(ip net.IP) bool {
+	return t != nil && NetworksContain(t.Networks, ip)
+}
+
+// Contains reports whether the peer at addr is trusted.
+func (t *TrustedPeers) Contains(addr net.Addr) bool {
+	if t == nil {
+		return false
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return NetworksContain(t.Networks, a.IP)
+	case *net.UnixAddr:
+		return t.Unix
+	}
+	return false
+}
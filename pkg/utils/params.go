@@ -63,6 +63,58 @@ func IntegerFromParameters(params url.Values, key string, defaultValue int) int
 	return defaultValue
 }
 
+func FloatFromParameters(params url.Values, key string, defaultValue float64) float64 {
+	if value, found := GetParameter(params, key); found {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// SizeFromParameters reads a size in bytes. "K", "M", "G" and "T" suffixes multiply it by powers of 1024.
+func SizeFromParameters(params url.Values, key string, defaultValue int64) int64 {
+	if value, found := GetParameter(params, key); found {
+		parsed, err := ParseSize(value)
+		if err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	if len(value) > 0 {
+		if i := strings.IndexByte("KMGT", value[len(value)-1]); i >= 0 {
+			multiplier = int64(1) << (10 * (i + 1))
+			value = value[:len(value)-1]
+		}
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return parsed * multiplier, nil
+}
+
+// FormatSize formats size for display, using the same suffixes as ParseSize.
+func FormatSize(size int64) string {
+	const units = "KMGT"
+	if size < 1024 {
+		return strconv.FormatInt(size, 10)
+	}
+	value := float64(size)
+	i := -1
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + units[i:i+1]
+}
+
 func BoolFromParameters(params url.Values, key string, defaultValue bool) bool {
 	if value, found := GetParameter(params, key); found {
 		parsed, err := ParseBool(value)
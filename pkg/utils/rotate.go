@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile appends to a file, which is renamed to "PATH.1" once it would grow beyond maxSize. older files are
+// shifted to "PATH.2" and so on, and those beyond maxBackups are removed.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+	closed     bool
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames the file and opens a new one at path. if that fails, the file at path is opened again, so that
+// writing goes on, and the error is returned.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err == nil {
+		err = f.shift()
+	}
+	if err != nil {
+		if openErr := f.open(); openErr != nil {
+			f.file = nil
+		}
+		return err
+	}
+	if err = f.open(); err != nil {
+		f.file = nil
+	}
+	return err
+}
+
+// shift renames the file and its backups to the next number, removing the last one.
+func (f *RotatingFile) shift() error {
+	_ = os.Remove(f.backupPath(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(f.backupPath(i), f.backupPath(i+1))
+	}
+	if f.maxBackups > 0 {
+		return os.Rename(f.path, f.backupPath(1))
+	}
+	return os.Remove(f.path)
+}
+
+func (f *RotatingFile) backupPath(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+// Write writes p at once, rotating the file before if needed, so that lines are never split between files. if
+// rotating fails, p is still written, to the file which grows beyond maxSize, and the error is returned.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.file != nil && f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		rotateErr = f.rotate()
+	}
+	if f.file == nil {
+		// opening failed last time. it's tried again with each write.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -1,23 +1,44 @@
 package wsconn
 
 import (
+	"bytes"
+	"encoding/base64"
 	"github.com/gorilla/websocket"
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
 	"github.com/hadi77ir/wsproxy/pkg/utils"
 	"io"
 	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 const maxConsecutiveEmptyReads = 100
+const closeGracePeriod = time.Second
 
 type Conn struct {
 	base   *websocket.Conn
 	reader io.Reader
 	closed chan struct{}
+	// dialed connections own the underlying connection, and have to close it themselves.
+	dialed    bool
+	compress  bool
+	threshold int
+	textMode  bool
+	// remoteAddr overrides address of the peer, when known through forwarding headers.
+	remoteAddr net.Addr
+	bytesIn    int64
+	bytesOut   int64
+	// halfClose is set when both sides agreed on half-closing with empty messages.
+	halfClose   bool
+	writeClosed atomic.Bool
 }
 
 func (c *Conn) Read(b []byte) (n int, err error) {
-	return c.reader.Read(b)
+	n, err = c.reader.Read(b)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	return
 }
 
 func (c *Conn) nextReader() (io.Reader, error) {
@@ -28,36 +49,95 @@ func (c *Conn) nextReader() (io.Reader, error) {
 			return nil, err
 		}
 		if msgType == websocket.BinaryMessage {
+			if c.halfClose {
+				return c.checkEOF(reader)
+			}
 			return reader, nil
 		}
+		if msgType == websocket.TextMessage && c.textMode {
+			return base64.NewDecoder(base64.StdEncoding, reader), nil
+		}
 	}
 	_ = c.Close()
 	return nil, io.EOF
 }
 
+// checkEOF reads the first byte of a message, to tell empty ones, which mean the peer has closed writing, apart.
+func (c *Conn) checkEOF(reader io.Reader) (io.Reader, error) {
+	first := make([]byte, 1)
+	n, err := reader.Read(first)
+	for n == 0 && err == nil {
+		n, err = reader.Read(first)
+	}
+	if n == 0 {
+		if err == io.EOF {
+			// no more readers. the connection stays open for writing.
+			return nil, io.EOF
+		}
+		_ = c.Close()
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(first), reader), nil
+}
+
 func (c *Conn) Write(b []byte) (n int, err error) {
-	err = c.base.WriteMessage(websocket.BinaryMessage, b)
+	if c.writeClosed.Load() {
+		return 0, net.ErrClosed
+	}
+	if c.compress {
+		// small messages don't benefit from compression, they only cost cpu time.
+		c.base.EnableWriteCompression(len(b) >= c.threshold)
+	}
+	if c.textMode {
+		encoded := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+		base64.StdEncoding.Encode(encoded, b)
+		err = c.base.WriteMessage(websocket.TextMessage, encoded)
+	} else {
+		err = c.base.WriteMessage(websocket.BinaryMessage, b)
+	}
 	if err != nil {
 		_ = c.Close()
 		return
 	}
 	n = len(b)
+	atomic.AddInt64(&c.bytesOut, int64(n))
 	return
 }
 
 func (c *Conn) Close() error {
+	wasOpen := c.isOpen()
 	c.tryClose()
-	// there is no need to close the underlying "base" connection.
-	// in the listener, it gets closed when handler returns, which is after we close "closed" channel.
-	// for dialed connections, closure should be handled by the underlying dialer. there is nothing more to do here.
+	// in the listener, "base" connection gets closed when handler returns, which is after we close "closed" channel.
+	// for dialed connections, nobody else is going to close it.
+	if c.dialed && wasOpen {
+		_ = c.base.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(closeGracePeriod))
+		return c.base.Close()
+	}
 	return nil
 }
 
+// CloseWrite tells the peer no more data is going to be sent, with an empty message. it's only supported when the
+// peer has agreed to it during the handshake.
+func (c *Conn) CloseWrite() error {
+	if !c.halfClose {
+		return E.ErrOpNotSupported
+	}
+	if c.writeClosed.Swap(true) {
+		return nil
+	}
+	return c.base.WriteMessage(websocket.BinaryMessage, nil)
+}
+
 func (c *Conn) LocalAddr() net.Addr {
 	return c.base.LocalAddr()
 }
 
 func (c *Conn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
 	return c.base.RemoteAddr()
 }
 
@@ -79,6 +159,29 @@ func (c *Conn) SetWriteDeadline(t time.Time) error {
 	return c.base.SetWriteDeadline(t)
 }
 
+// NetConn returns the underlying connection, which carries WebSocket frames.
+func (c *Conn) NetConn() net.Conn {
+	return c.base.UnderlyingConn()
+}
+
+// Stats returns byte counters of this connection. wire counters are only available when compression is enabled.
+func (c *Conn) Stats() Stats {
+	stats := Stats{
+		MessageBytesIn:  atomic.LoadInt64(&c.bytesIn),
+		MessageBytesOut: atomic.LoadInt64(&c.bytesOut),
+	}
+	if metered, ok := c.base.UnderlyingConn().(*meteredConn); ok {
+		stats.WireBytesIn = atomic.LoadInt64(&metered.bytesIn)
+		stats.WireBytesOut = atomic.LoadInt64(&metered.bytesOut)
+	}
+	return stats
+}
+
+// Compressed reports whether permessage-deflate has been enabled for this connection.
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
 func (c *Conn) CloseChan() <-chan struct{} {
 	return c.closed
 }
@@ -102,7 +205,37 @@ func (c *Conn) tryClose() {
 var _ net.Conn = &Conn{}
 
 func WrapConn(conn *websocket.Conn) *Conn {
+	return wrapConn(conn, Options{}, false)
+}
+
+// wrapConn wraps conn once the handshake is done. compressed tells whether permessage-deflate has been negotiated in
+// the handshake.
+func wrapConn(conn *websocket.Conn, opts Options, compressed bool) *Conn {
 	c := &Conn{base: conn, closed: make(chan struct{}, 1)}
 	c.reader = utils.NewMultiReader(c.isOpen, c.nextReader)
+	// websockify clients that negotiate "base64" send and expect text messages.
+	c.textMode = conn.Subprotocol() == SubprotocolBase64
+	if opts.Compression && compressed {
+		c.compress = conn.SetCompressionLevel(opts.CompressionLevel) == nil
+		c.threshold = opts.CompressionThreshold
+	}
+	if metered, ok := conn.UnderlyingConn().(*meteredConn); ok {
+		// only frames are counted, not the handshake.
+		metered.reset()
+	}
 	return c
 }
+
+// offersDeflate tells whether permessage-deflate is among extensions in the Sec-WebSocket-Extensions header, which is
+// what a client offers, or what a server has accepted of it.
+func offersDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package wsconn
 import (
 	"context"
 	"net"
+	"net/http"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,10 +18,15 @@ func DialCustomWS(addr string, dialer *websocket.Dialer) (net.Conn, error) {
 		return nil, err
 	}
 	// wrap
-	return WrapConn(ws), nil
+	conn := WrapConn(ws)
+	conn.dialed = true
+	return conn, nil
 }
 
-func WSClient(addr string, conn net.Conn, readBufSize, writeBufSize int) (net.Conn, error) {
+func WSClient(addr string, conn net.Conn, opts Options) (net.Conn, error) {
+	if opts.Compression {
+		conn = &meteredConn{Conn: conn}
+	}
 	dialer := websocket.Dialer{
 		NetDialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 			return conn, nil
@@ -28,15 +34,24 @@ func WSClient(addr string, conn net.Conn, readBufSize, writeBufSize int) (net.Co
 		NetDialTLSContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 			return conn, nil
 		},
-		ReadBufferSize:  readBufSize,
-		WriteBufferSize: writeBufSize,
+		ReadBufferSize:    opts.ReadBufferSize,
+		WriteBufferSize:   opts.WriteBufferSize,
+		EnableCompression: opts.Compression,
+		Subprotocols:      opts.Subprotocols,
 	}
 
-	ws, _, err := dialer.Dial(addr, nil)
+	var header http.Header
+	if opts.HalfClose {
+		header = http.Header{HeaderHalfClose: {"1"}}
+	}
+	ws, response, err := dialer.Dial(addr, header)
 	if err != nil {
 		return nil, err
 	}
 
 	// wrap
-	return WrapConn(ws), nil
+	wrapped := wrapConn(ws, opts, offersDeflate(response.Header))
+	wrapped.dialed = true
+	wrapped.halfClose = opts.HalfClose && !wrapped.textMode && response.Header.Get(HeaderHalfClose) == "1"
+	return wrapped, nil
 }
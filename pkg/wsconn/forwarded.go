@@ -0,0 +1,98 @@
+package wsconn
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+// clientAddr returns the address of the client which made the request. forwarding headers are only honoured if the
+// request has been made by a trusted proxy. returns nil if the address of the peer should be used.
+func clientAddr(request *http.Request, trusted *utils.TrustedPeers) net.Addr {
+	if trusted == nil || !peerTrusted(request, trusted) {
+		return nil
+	}
+
+	var chain []*net.TCPAddr
+	if forwarded := request.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else if xff := request.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, header := range xff {
+			for _, part := range strings.Split(header, ",") {
+				chain = append(chain, parseHostPort(strings.TrimSpace(part)))
+			}
+		}
+	} else if realIP := request.Header.Get("X-Real-IP"); realIP != "" {
+		chain = append(chain, parseHostPort(strings.TrimSpace(realIP)))
+	}
+
+	// walk from the nearest hop and stop at the first one we don't trust. anything beyond that may be spoofed.
+	var client *net.TCPAddr
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i] == nil {
+			break
+		}
+		client = chain[i]
+		if !trusted.ContainsIP(client.IP) {
+			break
+		}
+	}
+	if client == nil {
+		return nil
+	}
+	return client
+}
+
+// peerTrusted tells whether the request was made by a trusted proxy. peers connected through Unix sockets have no
+// address, so whether they are trusted doesn't depend on it.
+func peerTrusted(request *http.Request, trusted *utils.TrustedPeers) bool {
+	if _, ok := request.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return trusted.Unix
+	}
+	peer := parseHostPort(request.RemoteAddr)
+	return peer != nil && trusted.ContainsIP(peer.IP)
+}
+
+// parseForwarded extracts "for" parameters of Forwarded headers, as defined in RFC 7239.
+func parseForwarded(headers []string) []*net.TCPAddr {
+	var chain []*net.TCPAddr
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, parseHostPort(strings.Trim(value, "\"")))
+			}
+		}
+	}
+	return chain
+}
+
+// parseHostPort accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port". returns nil for anything else, including
+// obfuscated identifiers and "unknown".
+func parseHostPort(value string) *net.TCPAddr {
+	if ip := net.ParseIP(value); ip != nil {
+		return &net.TCPAddr{IP: ip}
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		port = ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	addr := &net.TCPAddr{IP: ip}
+	if port != "" {
+		if portNum, err := strconv.Atoi(port); err == nil {
+			addr.Port = portNum
+		}
+	}
+	return addr
+}
@@ -4,6 +4,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"strings"
 
 	"github.com/gorilla/websocket"
@@ -11,12 +13,14 @@ import (
 )
 
 type Listener struct {
-	backlog  chan *Conn
-	addr     net.Addr
-	upgrader *websocket.Upgrader
-	server   *http.Server
-	path     string
-	err      error
+	backlog    chan *Conn
+	addr       net.Addr
+	upgrader   *websocket.Upgrader
+	server     *http.Server
+	fileServer http.Handler
+	path       string
+	opts       Options
+	err        error
 }
 
 func (l *Listener) Close() error {
@@ -30,6 +34,9 @@ func (l *Listener) serve(listener net.Listener) {
 	if listener == nil {
 		err = l.server.ListenAndServe()
 	} else {
+		if l.opts.Compression {
+			listener = &meteredListener{Listener: listener}
+		}
 		err = l.server.Serve(listener)
 		_ = listener.Close()
 	}
@@ -40,15 +47,28 @@ func (l *Listener) serve(listener net.Listener) {
 	close(l.backlog)
 }
 func (l *Listener) handle(response http.ResponseWriter, request *http.Request) {
+	if l.fileServer != nil && !websocket.IsWebSocketUpgrade(request) &&
+		(request.Method == http.MethodGet || request.Method == http.MethodHead) {
+		l.fileServer.ServeHTTP(response, request)
+		return
+	}
 	if l.path != request.RequestURI {
 		http.NotFound(response, request)
 		return
 	}
-	conn, err := l.upgrader.Upgrade(response, request, nil)
+	var header http.Header
+	halfClose := l.opts.HalfClose && request.Header.Get(HeaderHalfClose) == "1"
+	if halfClose {
+		header = http.Header{HeaderHalfClose: {"1"}}
+	}
+	conn, err := l.upgrader.Upgrade(response, request, header)
 	if err != nil {
 		return
 	}
-	wrapped := WrapConn(conn)
+	// the upgrader accepts permessage-deflate whenever the client offers it, if compression is enabled.
+	wrapped := wrapConn(conn, l.opts, offersDeflate(request.Header))
+	wrapped.halfClose = halfClose && !wrapped.textMode
+	wrapped.remoteAddr = clientAddr(request, l.opts.TrustedProxies)
 	l.backlog <- wrapped
 	<-wrapped.CloseChan()
 }
@@ -77,7 +97,7 @@ func (h HttpHandler) ServeHTTP(response http.ResponseWriter, request *http.Reque
 }
 
 // when "innerListener" is set to null, will start listening on address defined in "addr"
-func WSServe(addr string, backlog int, innerListener net.Listener, readBufSize, writeBufSize int) (net.Listener, error) {
+func WSServe(addr string, backlog int, innerListener net.Listener, opts Options) (net.Listener, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
@@ -90,18 +110,34 @@ func WSServe(addr string, backlog int, innerListener net.Listener, readBufSize,
 		u.Path = "/"
 	}
 
+	var fileServer http.Handler
+	if opts.WebRoot != "" {
+		info, err := os.Stat(opts.WebRoot)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, E.ErrNotDirectory
+		}
+		fileServer = http.FileServer(noListingFS{http.Dir(opts.WebRoot)})
+	}
+
 	listener := &Listener{
-		server:  &http.Server{Addr: addr},
-		addr:    wsAddr(addr),
-		path:    u.RequestURI(),
-		backlog: make(chan *Conn, backlog),
+		server:     &http.Server{Addr: addr},
+		addr:       wsAddr(addr),
+		path:       u.RequestURI(),
+		opts:       opts,
+		fileServer: fileServer,
+		backlog:    make(chan *Conn, backlog),
 		upgrader: &websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				// todo: check origin
 				return true
 			},
-			ReadBufferSize:  readBufSize,
-			WriteBufferSize: writeBufSize,
+			ReadBufferSize:    opts.ReadBufferSize,
+			WriteBufferSize:   opts.WriteBufferSize,
+			EnableCompression: opts.Compression,
+			Subprotocols:      opts.Subprotocols,
 		},
 	}
 
@@ -111,6 +147,32 @@ func WSServe(addr string, backlog int, innerListener net.Listener, readBufSize,
 	return listener, nil
 }
 
+// noListingFS hides directories which have no "index.html", so that their contents aren't listed.
+type noListingFS struct {
+	http.FileSystem
+}
+
+func (fs noListingFS) Open(name string) (http.File, error) {
+	file, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := fs.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			_ = file.Close()
+			return nil, os.ErrNotExist
+		}
+		_ = index.Close()
+	}
+	return file, nil
+}
+
 type wsAddr string
 
 func (w wsAddr) Network() string {
@@ -0,0 +1,81 @@
+package wsconn
+
+import (
+	"compress/flate"
+	"net/url"
+
+	E "github.com/hadi77ir/wsproxy/pkg/errors"
+	"github.com/hadi77ir/wsproxy/pkg/utils"
+)
+
+const (
+	ParamReadBuffer           = "ws.read_buffer"
+	ParamWriteBuffer          = "ws.write_buffer"
+	ParamCompression          = "ws.compression"
+	ParamCompressionLevel     = "ws.compression_level"
+	ParamCompressionThreshold = "ws.compression_threshold"
+	ParamSubprotocols         = "ws.subprotocols"
+	ParamWebRoot              = "ws.webroot"
+	ParamTrustedProxies       = "ws.trusted_proxies"
+	ParamHalfClose            = "ws.half_close"
+)
+
+// HeaderHalfClose is sent by clients in the handshake to offer half-closing, and echoed by servers to accept it. once
+// accepted, an empty binary message means the sender won't send any more data.
+const HeaderHalfClose = "X-Wsproxy-Half-Close"
+
+// Subprotocols defined by websockify. "base64" carries data as base64-encoded text messages, for clients that don't
+// support binary messages.
+const (
+	SubprotocolBinary = "binary"
+	SubprotocolBase64 = "base64"
+)
+
+const defaultCompressionThreshold = 256
+
+// Options holds settings shared by WebSocket clients and servers.
+type Options struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Compression enables negotiation of permessage-deflate extension.
+	Compression bool
+	// CompressionLevel is passed to compress/flate. only used when compression is negotiated.
+	CompressionLevel int
+	// CompressionThreshold is the minimum size of a message, in bytes, to be sent compressed.
+	CompressionThreshold int
+	// Subprotocols are offered by servers in order of preference and requested by clients.
+	Subprotocols []string
+	// WebRoot is a directory to serve files from for requests which aren't WebSocket upgrades. servers only.
+	WebRoot string
+	// TrustedProxies are peers from which forwarding headers are honoured. servers only.
+	TrustedProxies *utils.TrustedPeers
+	// HalfClose enables negotiation of half-closing with the peer.
+	HalfClose bool
+}
+
+// ParseOptions reads WebSocket options from transport parameters. isServer determines default values.
+func ParseOptions(params url.Values, isServer bool) (Options, error) {
+	var defaultSubprotocols []string
+	if isServer {
+		defaultSubprotocols = []string{SubprotocolBinary, SubprotocolBase64}
+	}
+	opts := Options{
+		ReadBufferSize:       utils.IntegerFromParameters(params, ParamReadBuffer, 0),
+		WriteBufferSize:      utils.IntegerFromParameters(params, ParamWriteBuffer, 0),
+		Compression:          utils.BoolFromParameters(params, ParamCompression, false),
+		CompressionLevel:     utils.IntegerFromParameters(params, ParamCompressionLevel, flate.BestSpeed),
+		CompressionThreshold: utils.IntegerFromParameters(params, ParamCompressionThreshold, defaultCompressionThreshold),
+		Subprotocols:         utils.MultiStringFromParameters(params, ParamSubprotocols, defaultSubprotocols),
+		WebRoot:              utils.StringFromParameters(params, ParamWebRoot, ""),
+		HalfClose:            utils.BoolFromParameters(params, ParamHalfClose, true),
+	}
+	if opts.CompressionLevel < flate.HuffmanOnly || opts.CompressionLevel > flate.BestCompression {
+		return Options{}, E.ErrCompressionLevelOutOfRange
+	}
+	var err error
+	opts.TrustedProxies, err = utils.TrustedPeersFromParameters(params, ParamTrustedProxies)
+	if err != nil {
+		return Options{}, err
+	}
+	return opts, nil
+}
@@ -0,0 +1,69 @@
+package wsconn
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Stats holds byte counters of a WebSocket connection. Message counters are payload sizes as seen by the
+// application, while wire counters are the bytes transferred over the underlying connection, including framing.
+type Stats struct {
+	MessageBytesIn  int64
+	MessageBytesOut int64
+	WireBytesIn     int64
+	WireBytesOut    int64
+}
+
+// Ratio returns the ratio of bytes sent on the wire to the size of messages, in both directions.
+// values lower than 1 mean that compression has been effective.
+func (s Stats) Ratio() float64 {
+	messageBytes := s.MessageBytesIn + s.MessageBytesOut
+	if messageBytes == 0 {
+		return 1
+	}
+	return float64(s.WireBytesIn+s.WireBytesOut) / float64(messageBytes)
+}
+
+type meteredConn struct {
+	net.Conn
+	bytesIn  int64
+	bytesOut int64
+}
+
+func (c *meteredConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	return
+}
+
+func (c *meteredConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	return
+}
+
+// reset starts counting over.
+func (c *meteredConn) reset() {
+	atomic.StoreInt64(&c.bytesIn, 0)
+	atomic.StoreInt64(&c.bytesOut, 0)
+}
+
+func (c *meteredConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+var _ net.Conn = &meteredConn{}
+
+type meteredListener struct {
+	net.Listener
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &meteredConn{Conn: conn}, nil
+}
+
+var _ net.Listener = &meteredListener{}